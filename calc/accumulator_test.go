@@ -0,0 +1,278 @@
+package calc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+// randomData returns n pairs of (x, y),
+// either 0/1 indicators or values with a large offset,
+// which lose precision in raw sums.
+func randomData(r *rand.Rand, n int) (xs, ys []float64) {
+	offset := 0.0
+	if r.Intn(2) == 0 {
+		offset = 1e9
+	}
+	for i := 0; i < n; i++ {
+		var x, y float64
+		if offset == 0 {
+			x = float64(r.Intn(2))
+			y = x
+			if r.Intn(10) == 0 {
+				y = 1 - y
+			}
+		} else {
+			x = offset + r.NormFloat64()
+			y = x + r.NormFloat64()
+		}
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+	return
+}
+
+// twoPass returns means, variances and the covariance,
+// computed by two passes.
+func twoPass(xs, ys []float64) (mx, my, vx, vy, cov float64) {
+	n := float64(len(xs))
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		vx += dx * dx
+		vy += dy * dy
+		cov += dx * dy
+	}
+	return mx, my, vx / n, vy / n, cov / n
+}
+
+// closeTo checks relative errors,
+// which would be about 100 for raw sums of the offset data.
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// splitAccumulate increments data in random parts and appends them.
+func splitAccumulate(r *rand.Rand, xs, ys []float64) (*Covariance, *MeanVar) {
+	cov, mv := NewCovariance(), NewMeanVar()
+	for i := 0; i < len(xs); {
+		j := i + 1 + r.Intn(len(xs)-i)
+		c1, m1 := NewCovariance(), NewMeanVar()
+		for ; i < j; i++ {
+			c1.Increment(xs[i], ys[i])
+			m1.Increment(xs[i])
+		}
+		cov.Append(c1)
+		mv.Append(m1)
+	}
+	return cov, mv
+}
+
+func TestCovarianceTwoPass(t *testing.T) {
+	f := func(seed int64, size uint16) bool {
+		r := rand.New(rand.NewSource(seed))
+		xs, ys := randomData(r, 2+int(size)%5000)
+		mx, my, vx, vy, c := twoPass(xs, ys)
+
+		online := NewCovariance()
+		for i := range xs {
+			online.Increment(xs[i], ys[i])
+		}
+		merged, _ := splitAccumulate(r, xs, ys)
+
+		for _, cov := range []*Covariance{online, merged} {
+			if !closeTo(cov.GetMeanX(), mx) || !closeTo(cov.GetMeanY(), my) ||
+				!closeTo(cov.GetVarX(), vx) || !closeTo(cov.GetVarY(), vy) ||
+				!closeTo(cov.GetResult(), c) || cov.GetN() != len(xs) {
+				t.Logf("expect %g %g %g %g %g, got %+v\n", mx, my, vx, vy, c, cov)
+				return false
+			}
+			if cov.GetVarX() < 0 || cov.GetVarY() < 0 {
+				return false
+			}
+			if vx > 0 && vy > 0 && !closeTo(cov.GetCorrelation(), c/math.Sqrt(vx*vy)) {
+				t.Logf("expect correlation %g, got %g\n", c/math.Sqrt(vx*vy), cov.GetCorrelation())
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCorrelationSE compares standard errors of correlations
+// with their spread in replicates of bivariate normal data.
+func TestCorrelationSE(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rho, n, reps := 0.5, 200, 2000
+	corrs := NewMeanVar()
+	var se float64
+	for k := 0; k < reps; k++ {
+		cov := NewCovariance()
+		for i := 0; i < n; i++ {
+			x := r.NormFloat64()
+			y := rho*x + math.Sqrt(1-rho*rho)*r.NormFloat64()
+			cov.Increment(x, y)
+		}
+		corrs.Increment(cov.GetCorrelation())
+		se += cov.GetCorrelationSE() / float64(reps)
+	}
+
+	sd := math.Sqrt(corrs.GetVar())
+	if math.Abs(corrs.GetMean()-rho) > 0.01 || math.Abs(se-sd) > 0.1*sd {
+		t.Errorf("expect correlation %g with SE %g, got %g with SD %g\n", rho, se, corrs.GetMean(), sd)
+	}
+	if !math.IsNaN(NewCovarianceFromSums(3, 1, 2, 1, 2, 1).GetCorrelationSE()) {
+		t.Error("expect NaN of three data points\n")
+	}
+}
+
+// TestWeightedSamplingVar compares sampling variances of weighted covariances,
+// of the effective sample size, with their spread in replicates.
+func TestWeightedSamplingVar(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rho, n, reps := 0.5, 200, 2000
+	covs := NewMeanVar()
+	var v float64
+	for k := 0; k < reps; k++ {
+		cov := NewCovariance()
+		for i := 0; i < n; i++ {
+			x := r.NormFloat64()
+			y := rho*x + math.Sqrt(1-rho*rho)*r.NormFloat64()
+			cov.IncrementWeighted(x, y, float64(1+8*(i%2)))
+		}
+		covs.Increment(cov.GetResult())
+		v += cov.GetSamplingVar() / float64(reps)
+	}
+
+	if n1 := NewCovarianceFromSums(n, 0, 0, 0, 0, 0).GetEffectiveN(); n1 != float64(n) {
+		t.Errorf("expect effective N %d of unweighted data, got %g\n", n, n1)
+	}
+	sd, se := math.Sqrt(covs.GetVar()), math.Sqrt(v)
+	if math.Abs(se-sd) > 0.1*sd {
+		t.Errorf("expect SE %g of weighted covariances, got SD %g\n", se, sd)
+	}
+}
+
+func TestMeanVarTwoPass(t *testing.T) {
+	f := func(seed int64, size uint16) bool {
+		r := rand.New(rand.NewSource(seed))
+		xs, ys := randomData(r, 2+int(size)%5000)
+		mx, _, vx, _, _ := twoPass(xs, ys)
+		n := float64(len(xs))
+		vx = vx * n / (n - 1)
+
+		online := NewMeanVariances(1)
+		for _, x := range xs {
+			online.Increment(0, x)
+		}
+		_, merged := splitAccumulate(r, xs, ys)
+
+		if !closeTo(online.GetMean(0), mx) || !closeTo(online.GetVar(0), vx) || online.GetN(0) != len(xs) {
+			t.Logf("expect %g %g, got %g %g\n", mx, vx, online.GetMean(0), online.GetVar(0))
+			return false
+		}
+		if !closeTo(merged.GetMean(), mx) || !closeTo(merged.GetVar(), vx) || merged.N != len(xs) {
+			t.Logf("expect %g %g, got %+v\n", mx, vx, merged)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCovariancesAppend compares appended Covariances
+// with a Covariance of all data.
+func TestCovariancesAppend(t *testing.T) {
+	f := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		xs, ys := randomData(r, 1000)
+		a, b := NewCovariances(1), NewCovariances(1)
+		for i := range xs {
+			if i%3 == 0 {
+				a.Increment(0, xs[i], ys[i])
+			} else {
+				b.Increment(0, xs[i], ys[i])
+			}
+		}
+		a.Append(b)
+		mx, my, _, _, c := twoPass(xs, ys)
+		return closeTo(a.GetResult(0), c) && closeTo(a.GetMeanX(0), mx) && closeTo(a.GetMeanY(0), my)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMeanVarFrequencyWeights compares integer weights
+// with repeating data points.
+func TestMeanVarFrequencyWeights(t *testing.T) {
+	f := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		xs, ys := randomData(r, 200)
+		weighted, repeated := NewMeanVar(), NewMeanVar()
+		cw, cr := NewCovariance(), NewCovariance()
+		for i, x := range xs {
+			w := 1 + r.Intn(5)
+			weighted.IncrementWeighted(x, float64(w))
+			cw.IncrementWeighted(x, ys[i], float64(w))
+			for k := 0; k < w; k++ {
+				repeated.Increment(x)
+				cr.Increment(x, ys[i])
+			}
+		}
+		return closeTo(weighted.GetMean(), repeated.GetMean()) &&
+			closeTo(weighted.GetFreqVar(), repeated.GetVar()) &&
+			closeTo(cw.GetResult(), cr.GetResult())
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMeanVarReliabilityWeights compares the weighted variance
+// with two passes, in parts and appended.
+func TestMeanVarReliabilityWeights(t *testing.T) {
+	f := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		xs, _ := randomData(r, 500)
+		ws := make([]float64, len(xs))
+		var sw, sw2, mean float64
+		for i, x := range xs {
+			ws[i] = r.ExpFloat64()
+			sw += ws[i]
+			sw2 += ws[i] * ws[i]
+			mean += ws[i] * x
+		}
+		mean /= sw
+		var m2 float64
+		for i, x := range xs {
+			m2 += ws[i] * (x - mean) * (x - mean)
+		}
+		expected := m2 / (sw - sw2/sw)
+
+		a, b := NewMeanVar(), NewMeanVar()
+		for i, x := range xs {
+			if i%2 == 0 {
+				a.IncrementWeighted(x, ws[i])
+			} else {
+				b.IncrementWeighted(x, ws[i])
+			}
+		}
+		a.Append(b)
+		return closeTo(a.GetMean(), mean) && closeTo(a.GetVar(), expected)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,137 @@
+package calc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bins are sorted, non-overlapping ranges [Starts[i], Ends[i]) of lags.
+type Bins struct {
+	Starts, Ends []int
+}
+
+// NewLinearBins returns a bin for each lag less than maxl.
+func NewLinearBins(maxl int) *Bins {
+	b := Bins{}
+	for l := 0; l < maxl; l++ {
+		b.Starts = append(b.Starts, l)
+		b.Ends = append(b.Ends, l+1)
+	}
+	return &b
+}
+
+// NewLogBins returns a bin for each lag less than linear,
+// followed by log-spaced bins up to maxl,
+// with perDecade bins for a ten-fold increase of lags.
+// It panics if perDecade is not positive or linear is negative.
+func NewLogBins(linear, maxl, perDecade int) *Bins {
+	if perDecade <= 0 || linear < 0 {
+		panic("calc: log bins need positive bins per decade and non-negative linear lags")
+	}
+	if linear > maxl {
+		linear = maxl
+	}
+	b := NewLinearBins(linear)
+	factor := math.Pow(10, 1/float64(perDecade))
+	for start := linear; start < maxl; {
+		end := int(math.Floor(float64(start) * factor))
+		if end <= start {
+			end = start + 1
+		}
+		if end > maxl {
+			end = maxl
+		}
+		b.Starts = append(b.Starts, start)
+		b.Ends = append(b.Ends, end)
+		start = end
+	}
+	return b
+}
+
+// ReadBins reads bins of lines "start end" (end exclusive),
+// which are sorted and do not overlap.
+func ReadBins(r io.Reader) (*Bins, error) {
+	b := Bins{}
+	rd := bufio.NewReader(r)
+	for {
+		line, err := rd.ReadString('\n')
+		terms := strings.Fields(line)
+		if len(terms) >= 2 && !strings.HasPrefix(terms[0], "#") {
+			start, err1 := strconv.Atoi(terms[0])
+			end, err2 := strconv.Atoi(terms[1])
+			if err1 != nil || err2 != nil || start < 0 || end <= start {
+				return nil, fmt.Errorf("invalid bin: %s", strings.TrimSpace(line))
+			}
+			if n := len(b.Ends); n > 0 && start < b.Ends[n-1] {
+				return nil, fmt.Errorf("bins are not sorted or overlap: %s", strings.TrimSpace(line))
+			}
+			b.Starts = append(b.Starts, start)
+			b.Ends = append(b.Ends, end)
+		}
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+	}
+	if len(b.Starts) == 0 {
+		return nil, fmt.Errorf("no bins")
+	}
+	return &b, nil
+}
+
+// Bin returns the bin of lag l, or -1 if l is in no bin.
+func (b *Bins) Bin(l int) int {
+	i := sort.SearchInts(b.Starts, l+1) - 1
+	if i >= 0 && l < b.Ends[i] {
+		return i
+	}
+	return -1
+}
+
+// Len returns the number of bins.
+func (b *Bins) Len() int {
+	return len(b.Starts)
+}
+
+// Range returns the range [start, end) of lags in bin i.
+func (b *Bins) Range(i int) (start, end int) {
+	return b.Starts[i], b.Ends[i]
+}
+
+// MaxL returns the end of the last bin.
+func (b *Bins) MaxL() int {
+	if len(b.Ends) == 0 {
+		return 0
+	}
+	return b.Ends[len(b.Ends)-1]
+}
+
+// IsLinear returns true if every bin is a lag from 0.
+func (b *Bins) IsLinear() bool {
+	for i := range b.Starts {
+		if b.Starts[i] != i || b.Ends[i] != i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns true if both have the same bins.
+func (b *Bins) Equal(b1 *Bins) bool {
+	if b.Len() != b1.Len() {
+		return false
+	}
+	for i := range b.Starts {
+		if b.Starts[i] != b1.Starts[i] || b.Ends[i] != b1.Ends[i] {
+			return false
+		}
+	}
+	return true
+}
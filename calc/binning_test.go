@@ -0,0 +1,54 @@
+package calc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogBins(t *testing.T) {
+	b := NewLogBins(100, 100000, 10)
+	if b.MaxL() != 100000 {
+		t.Errorf("expect max lag 100000, got %d\n", b.MaxL())
+	}
+	if b.Len() > 200 {
+		t.Errorf("expect at most 200 bins, got %d\n", b.Len())
+	}
+	for l := 0; l < b.MaxL(); l++ {
+		i := b.Bin(l)
+		start, end := b.Range(i)
+		if l < start || l >= end || (l < 100 && end-start != 1) {
+			t.Fatalf("lag %d in bin [%d, %d)\n", l, start, end)
+		}
+	}
+	if b.Bin(b.MaxL()) != -1 || b.Bin(-1) != -1 {
+		t.Error("expect lags out of bins")
+	}
+
+	for _, perDecade := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expect a panic of %d bins per decade\n", perDecade)
+				}
+			}()
+			NewLogBins(100, 1000, perDecade)
+		}()
+	}
+}
+
+func TestReadBins(t *testing.T) {
+	b, err := ReadBins(strings.NewReader("# start end\n0 10\n20 30\n30 100\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]int{0: 0, 9: 0, 10: -1, 19: -1, 20: 1, 30: 2, 99: 2, 100: -1}
+	for l, i := range expected {
+		if b.Bin(l) != i {
+			t.Errorf("lag %d: expect bin %d, got %d\n", l, i, b.Bin(l))
+		}
+	}
+
+	if _, err := ReadBins(strings.NewReader("0 10\n5 20\n")); err == nil {
+		t.Error("expect an error of overlapping bins")
+	}
+}
@@ -1,47 +1,114 @@
 package calc
 
-import (
-	"github.com/mingzhi/gomath/stat/correlation"
-)
-
-// Calculator contains individual calculators.
+// Calculator contains statistics of site pairs (see Statistic).
+// Accumulators are for bins of lags (see Bins).
 type Calculator struct {
-	MaxL int
-	Cs   *MeanVariances
-	Cr   *Covariances
-	Ct   *Covariances
+	MaxL  int
+	Bins  *Bins
+	Names []string    // names of statistics.
+	Stats []Statistic // statistics in the order of names.
+	Pairs []int       // numbers of site pairs in bins.
+
+	// PairWeighted weights site pairs
+	// by their numbers of compared read pairs.
+	PairWeighted bool
 }
 
-// New returns a new Calculator
+// New returns a new Calculator of default statistics.
 func New(maxl int) *Calculator {
+	return NewBinned(NewLinearBins(maxl))
+}
+
+// NewBinned returns a new Calculator of default statistics in bins.
+func NewBinned(bins *Bins) *Calculator {
+	c, err := NewWithStatistics(bins, DefaultStatistics...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewWithStatistics returns a new Calculator of registered statistics in bins.
+func NewWithStatistics(bins *Bins, names ...string) (*Calculator, error) {
 	c := Calculator{}
-	c.MaxL = maxl
-	c.Cs = NewMeanVariances(maxl)
-	c.Cr = NewCovariances(maxl)
-	c.Ct = NewCovariances(maxl)
-	return &c
+	c.MaxL = bins.MaxL()
+	c.Bins = bins
+	c.Pairs = make([]int, bins.Len())
+	for _, name := range names {
+		s, err := NewStatistic(name, bins.Len())
+		if err != nil {
+			return nil, err
+		}
+		c.Names = append(c.Names, name)
+		c.Stats = append(c.Stats, s)
+	}
+	return &c, nil
+}
+
+// Stat returns the statistic of the name, or nil if it is not calculated.
+func (c *Calculator) Stat(name string) Statistic {
+	for k := range c.Names {
+		if c.Names[k] == name {
+			return c.Stats[k]
+		}
+	}
+	return nil
 }
 
 // Increment add x and y arrays, which separate at distance l.
+// Statistics of haplotypes, such as r^2, are not incremented.
 func (c *Calculator) Increment(xArr, yArr []float64, l int) {
-	if l < c.MaxL {
-        // calculate covariance of x and y.
-		cov := correlation.NewBivariateCovariance(false)
+	c.IncrementCompared(xArr, yArr, nil, l)
+}
+
+// IncrementCompared adds x and y arrays of compared read pairs,
+// and the haplotype table t of all read pairs (or nil),
+// of two sites separated at distance l.
+func (c *Calculator) IncrementCompared(xArr, yArr []float64, t *HaplotypeTable, l int) {
+	if i := c.Bins.Bin(l); i >= 0 {
+		// calculate covariance of x and y.
+		cov := NewCovariance()
 		for i := range xArr {
 			x, y := xArr[i], yArr[i]
 			cov.Increment(x, y)
 		}
-        
-		c.Cs.Increment(l, cov.GetResult())
-		c.Ct.AppendAt(l, cov)
-		c.Cr.Increment(l, cov.MeanX(), cov.MeanY())
 
+		c.increment(i, cov, t)
+	}
+}
+
+// IncrementTable adds comparisons of every two haplotypes
+// of two sites separated at distance l,
+// which is the same as Increment with all pairs of haplotypes.
+func (c *Calculator) IncrementTable(t *HaplotypeTable, l int) {
+	if i := c.Bins.Bin(l); i >= 0 {
+		c.increment(i, t.Covariance(), t)
 	}
 }
 
-// Append appends a calculator to another
+func (c *Calculator) increment(i int, cov *Covariance, t *HaplotypeTable) {
+	if cov.N == 0 {
+		return
+	}
+	p := SitePair{Cov: cov, Table: t, Weight: 1}
+	if c.PairWeighted {
+		p.Weight = cov.W
+	}
+	for _, s := range c.Stats {
+		s.Increment(i, &p)
+	}
+	c.Pairs[i]++
+}
+
+// Append appends a calculator to another.
+// Statistics not calculated by both are not appended.
 func (c *Calculator) Append(c1 *Calculator) {
-	c.Cs.Append(c1.Cs)
-	c.Cr.Append(c1.Cr)
-	c.Ct.Append(c1.Ct)
-}
\ No newline at end of file
+	for k, name := range c.Names {
+		if s1 := c1.Stat(name); s1 != nil {
+			c.Stats[k].Append(s1)
+		}
+	}
+	for i := range c.Pairs {
+		c.Pairs[i] += c1.Pairs[i]
+	}
+}
@@ -1,9 +1,17 @@
 package calc
 
-// Covariance contains cov structure
+import "math"
+
+// Covariance contains cov structure,
+// updated online (Welford 1962) and merged pairwise (Chan et al. 1979),
+// which is stable for many data points of small variance.
+// Data points can be weighted, as in MeanVar.
 type Covariance struct {
-	XY, X, Y float64
-	N        int
+	N            int
+	W, W2        float64 // sums of weights and squared weights.
+	MeanX, MeanY float64
+	C            float64 // sum of (x - mean x)(y - mean y).
+	M2X, M2Y     float64 // sums of squared deviations.
 }
 
 // NewCovariance create a Covariance
@@ -11,27 +19,75 @@ func NewCovariance() *Covariance {
 	return &Covariance{}
 }
 
+// NewCovarianceFromSums creates a Covariance of n data points
+// from their sums of x, y, x^2, y^2 and xy.
+func NewCovarianceFromSums(n int, x, y, xx, yy, xy float64) *Covariance {
+	c := Covariance{N: n}
+	if n > 0 {
+		fn := float64(n)
+		c.W, c.W2 = fn, fn
+		c.MeanX = x / fn
+		c.MeanY = y / fn
+		c.C = xy - x*y/fn
+		c.M2X = xx - x*x/fn
+		c.M2Y = yy - y*y/fn
+	}
+	return &c
+}
+
 // Increment add data to the calculator
 func (c *Covariance) Increment(x, y float64) {
-	c.XY += x * y
-	c.X += x
-	c.Y += y
+	c.IncrementWeighted(x, y, 1)
+}
+
+// IncrementWeighted adds data of weight w.
+// Data of non-positive weights are ignored.
+func (c *Covariance) IncrementWeighted(x, y, w float64) {
+	if !(w > 0) {
+		return
+	}
 	c.N++
+	c.W += w
+	c.W2 += w * w
+	dx := x - c.MeanX
+	dy := y - c.MeanY
+	c.MeanX += dx * (w / c.W)
+	c.MeanY += dy * (w / c.W)
+	c.C += w * dx * (y - c.MeanY)
+	c.M2X += w * dx * (x - c.MeanX)
+	c.M2Y += w * dy * (y - c.MeanY)
 }
 
 // Append merges another covariance.
 func (c *Covariance) Append(c1 *Covariance) {
-	c.XY += c1.XY
-	c.X += c1.X
-	c.Y += c1.Y
+	if c1.N == 0 {
+		return
+	}
+	if c.N == 0 {
+		*c = *c1
+		return
+	}
+
+	wa, wb := c.W, c1.W
+	w := wa + wb
+	dx := c1.MeanX - c.MeanX
+	dy := c1.MeanY - c.MeanY
+	c.MeanX += dx * (wb / w)
+	c.MeanY += dy * (wb / w)
+	c.C += c1.C + dx*dy*wa*wb/w
+	c.M2X += c1.M2X + dx*dx*wa*wb/w
+	c.M2Y += c1.M2Y + dy*dy*wa*wb/w
 	c.N += c1.N
+	c.W = w
+	c.W2 += c1.W2
 }
 
-// GetResult returns the result.
+// GetResult returns the (weighted) population covariance,
+// sum w (x - mean x)(y - mean y) / sum w,
+// without the correction of sample or reliability weights,
+// as the covariances of read pairs are of all compared pairs.
 func (c *Covariance) GetResult() float64 {
-	var v float64
-	v = c.XY/float64(c.N) - (c.X/float64(c.N))*(c.Y/float64(c.N))
-	return v
+	return c.C / c.W
 }
 
 // GetN returns N.
@@ -41,10 +97,62 @@ func (c *Covariance) GetN() int {
 
 // GetMeanX return mean of X.
 func (c *Covariance) GetMeanX() float64 {
-	return c.X / float64(c.N)
+	if c.N == 0 {
+		return math.NaN()
+	}
+	return c.MeanX
 }
 
 // GetMeanY returns mean of Y.
 func (c *Covariance) GetMeanY() float64 {
-	return c.Y / float64(c.N)
-}
\ No newline at end of file
+	if c.N == 0 {
+		return math.NaN()
+	}
+	return c.MeanY
+}
+
+// GetEffectiveN returns the effective sample size of weighted data,
+// (sum w)^2 / sum w^2 (Kish 1965), which is N if data are not weighted.
+func (c *Covariance) GetEffectiveN() float64 {
+	return c.W * c.W / c.W2
+}
+
+// GetVarX returns the population variance of X, as GetResult.
+func (c *Covariance) GetVarX() float64 {
+	return c.M2X / c.W
+}
+
+// GetVarY returns the population variance of Y, as GetResult.
+func (c *Covariance) GetVarY() float64 {
+	return c.M2Y / c.W
+}
+
+// GetSamplingVar returns the approximate sampling variance of the covariance,
+// which is (var(x) var(y) + cov^2) / n for normal data,
+// of the effective sample size n.
+func (c *Covariance) GetSamplingVar() float64 {
+	r := c.GetResult()
+	return (c.GetVarX()*c.GetVarY() + r*r) / c.GetEffectiveN()
+}
+
+// GetCorrelation returns the Pearson correlation coefficient,
+// which is NaN if either variance is zero.
+func (c *Covariance) GetCorrelation() float64 {
+	if c.M2X <= 0 || c.M2Y <= 0 {
+		return math.NaN()
+	}
+	return c.C / math.Sqrt(c.M2X*c.M2Y)
+}
+
+// GetCorrelationSE returns the approximate standard error of the correlation,
+// (1 - r^2) / sqrt(n - 3), by the delta method of Fisher's z transformation,
+// of the effective sample size n,
+// which is NaN for no more than three data points.
+func (c *Covariance) GetCorrelationSE() float64 {
+	n := c.GetEffectiveN()
+	if c.N == 0 || n <= 3 {
+		return math.NaN()
+	}
+	r := c.GetCorrelation()
+	return (1 - r*r) / math.Sqrt(n-3)
+}
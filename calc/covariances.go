@@ -1,21 +1,15 @@
 package calc
 
-import (
-	"github.com/mingzhi/gomath/stat/correlation"
-)
-
-
 // Covariances contains an array of Coveriance.
 type Covariances struct {
-	corrs []*correlation.BivariateCovariance
+	corrs []*Covariance
 }
 
 // NewCovariances create a new Covariances
 func NewCovariances(maxl int) *Covariances {
 	cc := Covariances{}
 	for i := 0; i < maxl; i++ {
-		bc := correlation.NewBivariateCovariance(false)
-		cc.corrs = append(cc.corrs, bc)
+		cc.corrs = append(cc.corrs, NewCovariance())
 	}
 	return &cc
 }
@@ -25,6 +19,11 @@ func (c *Covariances) Increment(l int, x, y float64) {
 	c.corrs[l].Increment(x, y)
 }
 
+// IncrementWeighted add data (x, y) of weight w to the l Covariance.
+func (c *Covariances) IncrementWeighted(l int, x, y, w float64) {
+	c.corrs[l].IncrementWeighted(x, y, w)
+}
+
 // Append append a Covariance to the l Covariance.
 func (c *Covariances) Append(c1 *Covariances) {
 	for i := 0; i < len(c.corrs); i++ {
@@ -32,8 +31,9 @@ func (c *Covariances) Append(c1 *Covariances) {
 	}
 }
 
-func (c *Covariances) AppendAt(i int, c1 *correlation.BivariateCovariance) {
-	c.corrs[i].Append(c1)
+// AppendAt merges a Covariance to the l Covariance.
+func (c *Covariances) AppendAt(l int, c1 *Covariance) {
+	c.corrs[l].Append(c1)
 }
 
 // GetResult returns the result.
@@ -48,10 +48,40 @@ func (c *Covariances) GetN(l int) int {
 
 // GetMeanX returns x_bar.
 func (c *Covariances) GetMeanX(l int) float64 {
-	return c.corrs[l].MeanX()
+	return c.corrs[l].GetMeanX()
 }
 
 // GetMeanY returns y_bar.
 func (c *Covariances) GetMeanY(l int) float64 {
-	return c.corrs[l].MeanY()
+	return c.corrs[l].GetMeanY()
+}
+
+// GetVarX returns the variance of x.
+func (c *Covariances) GetVarX(l int) float64 {
+	return c.corrs[l].GetVarX()
+}
+
+// GetVarY returns the variance of y.
+func (c *Covariances) GetVarY(l int) float64 {
+	return c.corrs[l].GetVarY()
+}
+
+// GetEffectiveN returns the effective sample size of weighted data.
+func (c *Covariances) GetEffectiveN(l int) float64 {
+	return c.corrs[l].GetEffectiveN()
+}
+
+// GetSamplingVar returns the sampling variance of the covariance.
+func (c *Covariances) GetSamplingVar(l int) float64 {
+	return c.corrs[l].GetSamplingVar()
+}
+
+// GetCorrelation returns the Pearson correlation coefficient.
+func (c *Covariances) GetCorrelation(l int) float64 {
+	return c.corrs[l].GetCorrelation()
+}
+
+// GetCorrelationSE returns the standard error of the correlation.
+func (c *Covariances) GetCorrelationSE(l int) float64 {
+	return c.corrs[l].GetCorrelationSE()
 }
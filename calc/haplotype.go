@@ -0,0 +1,83 @@
+package calc
+
+// HaplotypeTable counts two-site haplotypes of read pairs,
+// where rows are bases at the first site,
+// and columns are bases at the second site.
+type HaplotypeTable [4][4]int
+
+// baseIndex returns the index of a base in the table, or -1.
+func baseIndex(b byte) int {
+	switch b {
+	case 'A':
+		return 0
+	case 'T':
+		return 1
+	case 'G':
+		return 2
+	case 'C':
+		return 3
+	}
+	return -1
+}
+
+// Add adds a haplotype of bases a and b.
+// Haplotypes with other than A, T, G and C are ignored.
+func (t *HaplotypeTable) Add(a, b byte) {
+	i, j := baseIndex(a), baseIndex(b)
+	if i >= 0 && j >= 0 {
+		t[i][j]++
+	}
+}
+
+// Reset clears the table.
+func (t *HaplotypeTable) Reset() {
+	*t = HaplotypeTable{}
+}
+
+// N returns the number of haplotypes.
+func (t *HaplotypeTable) N() int {
+	n := 0
+	for i := range t {
+		for j := range t[i] {
+			n += t[i][j]
+		}
+	}
+	return n
+}
+
+// Covariance returns the covariance of comparing every two haplotypes,
+// where x (y) is 1 if they differ at the first (second) site.
+// A pair differs at a site unless both have the same base,
+// so the sums follow from counts of pairs sharing bases
+// in O(1) instead of O(n^2).
+func (t *HaplotypeTable) Covariance() *Covariance {
+	var rows, cols [4]int
+	var sameXY float64
+	n := 0
+	for i := range t {
+		for j := range t[i] {
+			c := t[i][j]
+			rows[i] += c
+			cols[j] += c
+			sameXY += choose2(c)
+			n += c
+		}
+	}
+
+	var sameX, sameY float64
+	for i := 0; i < 4; i++ {
+		sameX += choose2(rows[i])
+		sameY += choose2(cols[i])
+	}
+
+	// x and y are 0 or 1, so x^2 = x and y^2 = y.
+	pairs := choose2(n)
+	x := pairs - sameX
+	y := pairs - sameY
+	xy := pairs - sameX - sameY + sameXY
+	return NewCovarianceFromSums(int(pairs), x, y, x, y, xy)
+}
+
+func choose2(n int) float64 {
+	return float64(n) * float64(n-1) / 2
+}
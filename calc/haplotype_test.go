@@ -0,0 +1,65 @@
+package calc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestIncrementTable compares the haplotype table
+// with comparing every two haplotypes.
+func TestIncrementTable(t *testing.T) {
+	bases := []byte("ATGC")
+	r := rand.New(rand.NewSource(1))
+	maxl := 20
+	c1 := New(maxl)
+	c2 := New(maxl)
+	for l := 0; l < maxl; l++ {
+		n := 2 + r.Intn(200)
+		as := make([]byte, n)
+		bs := make([]byte, n)
+		var table HaplotypeTable
+		for i := 0; i < n; i++ {
+			// skewed base frequencies, linked at the two sites.
+			as[i] = bases[r.Intn(1+r.Intn(4))]
+			bs[i] = as[i]
+			if r.Intn(3) == 0 {
+				bs[i] = bases[r.Intn(4)]
+			}
+			table.Add(as[i], bs[i])
+		}
+
+		var xArr, yArr []float64
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				xArr = append(xArr, diff(as[i], as[j]))
+				yArr = append(yArr, diff(bs[i], bs[j]))
+			}
+		}
+
+		c1.Increment(xArr, yArr, l)
+		c2.IncrementTable(&table, l)
+	}
+
+	for l := 0; l < maxl; l++ {
+		if c1.Stat("ct").N(l) != c2.Stat("ct").N(l) {
+			t.Errorf("lag %d: expect N %d, got %d\n", l, c1.Stat("ct").N(l), c2.Stat("ct").N(l))
+		}
+		for _, name := range DefaultStatistics {
+			compare(t, l, name, c1.Stat(name).Result(l), c2.Stat(name).Result(l))
+		}
+	}
+}
+
+func diff(a, b byte) float64 {
+	if a != b {
+		return 1
+	}
+	return 0
+}
+
+func compare(t *testing.T, l int, name string, expected, got float64) {
+	if math.Abs(expected-got) > 1e-10 {
+		t.Errorf("lag %d: expect %s %g, got %g\n", l, name, expected, got)
+	}
+}
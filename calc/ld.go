@@ -0,0 +1,80 @@
+package calc
+
+import "math"
+
+// major returns the index of the most common base
+// in rows (site 1) or columns (site 2).
+func (t *HaplotypeTable) major(rows bool) int {
+	var counts [4]int
+	for i := range t {
+		for j := range t[i] {
+			if rows {
+				counts[i] += t[i][j]
+			} else {
+				counts[j] += t[i][j]
+			}
+		}
+	}
+	k := 0
+	for i := range counts {
+		if counts[i] > counts[k] {
+			k = i
+		}
+	}
+	return k
+}
+
+// frequencies returns frequencies of major bases A and B at two sites,
+// and of the haplotype AB,
+// where other bases are lumped together as minor alleles.
+func (t *HaplotypeTable) frequencies() (pA, pB, pAB float64) {
+	n := float64(t.N())
+	a, b := t.major(true), t.major(false)
+	for i := range t {
+		for j := range t[i] {
+			c := float64(t[i][j])
+			if i == a {
+				pA += c
+			}
+			if j == b {
+				pB += c
+			}
+			if i == a && j == b {
+				pAB += c
+			}
+		}
+	}
+	return pA / n, pB / n, pAB / n
+}
+
+// D returns the coefficient of linkage disequilibrium D = pAB - pA pB,
+// and the product of diversities pA pa pB pb,
+// and false if any site is not polymorphic.
+func (t *HaplotypeTable) D() (d, hz float64, ok bool) {
+	if t.N() == 0 {
+		return 0, 0, false
+	}
+	pA, pB, pAB := t.frequencies()
+	hz = pA * (1 - pA) * pB * (1 - pB)
+	if hz <= 0 {
+		return 0, 0, false
+	}
+	return pAB - pA*pB, hz, true
+}
+
+// DPrime returns D', which is D normalized by its maximum
+// given allele frequencies (Lewontin 1964).
+func (t *HaplotypeTable) DPrime() float64 {
+	d, _, ok := t.D()
+	if !ok {
+		return math.NaN()
+	}
+	pA, pB, _ := t.frequencies()
+	var dmax float64
+	if d > 0 {
+		dmax = math.Min(pA*(1-pB), (1-pA)*pB)
+	} else {
+		dmax = math.Min(pA*pB, (1-pA)*(1-pB))
+	}
+	return math.Abs(d) / dmax
+}
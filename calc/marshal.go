@@ -0,0 +1,156 @@
+package calc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Binary (gob) and JSON encodings of calculators,
+// which can be saved and appended later.
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MarshalBinary encodes the Covariances.
+func (c *Covariances) MarshalBinary() ([]byte, error) {
+	return gobEncode(c.corrs)
+}
+
+// UnmarshalBinary decodes the Covariances.
+func (c *Covariances) UnmarshalBinary(data []byte) error {
+	return gobDecode(data, &c.corrs)
+}
+
+// MarshalJSON encodes the Covariances.
+func (c *Covariances) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.corrs)
+}
+
+// UnmarshalJSON decodes the Covariances.
+func (c *Covariances) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.corrs)
+}
+
+// MarshalBinary encodes the MeanVariances.
+func (m *MeanVariances) MarshalBinary() ([]byte, error) {
+	return gobEncode(m.meanvars)
+}
+
+// UnmarshalBinary decodes the MeanVariances.
+func (m *MeanVariances) UnmarshalBinary(data []byte) error {
+	return gobDecode(data, &m.meanvars)
+}
+
+// MarshalJSON encodes the MeanVariances.
+func (m *MeanVariances) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.meanvars)
+}
+
+// UnmarshalJSON decodes the MeanVariances.
+func (m *MeanVariances) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &m.meanvars)
+}
+
+// calculatorState contains fields of a Calculator,
+// with statistics encoded by themselves,
+// which are decoded into new statistics of their names.
+type calculatorState struct {
+	MaxL         int
+	Bins         *Bins
+	Names        []string
+	Stats        [][]byte
+	Pairs        []int
+	PairWeighted bool
+}
+
+// jsonCalculatorState is the calculatorState in JSON.
+type jsonCalculatorState struct {
+	MaxL         int
+	Bins         *Bins
+	Names        []string
+	Stats        []json.RawMessage
+	Pairs        []int
+	PairWeighted bool
+}
+
+func (c *Calculator) setState(maxl int, bins *Bins, names []string, numStats int, pairs []int, pairWeighted bool) error {
+	if len(names) != numStats {
+		return fmt.Errorf("%d statistics of %d names", numStats, len(names))
+	}
+	c1, err := NewWithStatistics(bins, names...)
+	if err != nil {
+		return err
+	}
+	*c = *c1
+	c.MaxL, c.Pairs, c.PairWeighted = maxl, pairs, pairWeighted
+	return nil
+}
+
+// MarshalBinary encodes the Calculator.
+func (c *Calculator) MarshalBinary() ([]byte, error) {
+	s := calculatorState{MaxL: c.MaxL, Bins: c.Bins, Names: c.Names, Pairs: c.Pairs, PairWeighted: c.PairWeighted}
+	for _, stat := range c.Stats {
+		data, err := gobEncode(stat)
+		if err != nil {
+			return nil, err
+		}
+		s.Stats = append(s.Stats, data)
+	}
+	return gobEncode(&s)
+}
+
+// UnmarshalBinary decodes the Calculator.
+func (c *Calculator) UnmarshalBinary(data []byte) error {
+	var s calculatorState
+	if err := gobDecode(data, &s); err != nil {
+		return err
+	}
+	if err := c.setState(s.MaxL, s.Bins, s.Names, len(s.Stats), s.Pairs, s.PairWeighted); err != nil {
+		return err
+	}
+	for k := range c.Stats {
+		if err := gobDecode(s.Stats[k], c.Stats[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the Calculator.
+func (c *Calculator) MarshalJSON() ([]byte, error) {
+	s := jsonCalculatorState{MaxL: c.MaxL, Bins: c.Bins, Names: c.Names, Pairs: c.Pairs, PairWeighted: c.PairWeighted}
+	for _, stat := range c.Stats {
+		data, err := json.Marshal(stat)
+		if err != nil {
+			return nil, err
+		}
+		s.Stats = append(s.Stats, data)
+	}
+	return json.Marshal(&s)
+}
+
+// UnmarshalJSON decodes the Calculator.
+func (c *Calculator) UnmarshalJSON(data []byte) error {
+	var s jsonCalculatorState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := c.setState(s.MaxL, s.Bins, s.Names, len(s.Stats), s.Pairs, s.PairWeighted); err != nil {
+		return err
+	}
+	for k := range c.Stats {
+		if err := json.Unmarshal(s.Stats[k], c.Stats[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
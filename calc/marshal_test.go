@@ -0,0 +1,62 @@
+package calc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestMarshalCalculator encodes and decodes a calculator,
+// and compares the results.
+func TestMarshalCalculator(t *testing.T) {
+	maxl := 5
+	c, err := NewWithStatistics(NewLinearBins(maxl), append(DefaultStatistics, LDStatistics...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for l := 0; l < maxl; l++ {
+		var table HaplotypeTable
+		for i := 0; i <= l+3; i++ {
+			table.Add("ATGC"[i%2], "ATGC"[(i+l)%3])
+		}
+		c.IncrementTable(&table, l)
+	}
+
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1 := &Calculator{}
+	if err := c1.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	compareCalculators(t, c, c1)
+
+	data, err = json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2 := &Calculator{}
+	if err := json.Unmarshal(data, c2); err != nil {
+		t.Fatal(err)
+	}
+	compareCalculators(t, c, c2)
+
+	// decoded calculators can be appended.
+	c1.Append(c2)
+	if c1.Stat("ct").N(1) != 2*c.Stat("ct").N(1) {
+		t.Errorf("expect N %d, got %d\n", 2*c.Stat("ct").N(1), c1.Stat("ct").N(1))
+	}
+}
+
+func compareCalculators(t *testing.T, c, c1 *Calculator) {
+	if c1.MaxL != c.MaxL || len(c1.Stats) != len(c.Stats) {
+		t.Fatalf("expect MaxL %d and %d statistics, got %d and %d\n", c.MaxL, len(c.Stats), c1.MaxL, len(c1.Stats))
+	}
+	for l := 0; l < c.MaxL; l++ {
+		for k, name := range c.Names {
+			if c.Stats[k].N(l) > 0 {
+				compare(t, l, name, c.Stats[k].Result(l), c1.Stat(name).Result(l))
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package calc
+
+import "math"
+
+// MeanVar accumulates the (weighted) mean and variance of data,
+// updated online (West 1979) and merged pairwise as Covariance.
+type MeanVar struct {
+	N    int
+	W    float64 // sum of weights.
+	W2   float64 // sum of squared weights.
+	Mean float64
+	M2   float64 // weighted sum of squared deviations.
+}
+
+// NewMeanVar returns a new MeanVar.
+func NewMeanVar() *MeanVar {
+	return &MeanVar{}
+}
+
+// Increment adds a data point.
+func (m *MeanVar) Increment(v float64) {
+	m.IncrementWeighted(v, 1)
+}
+
+// IncrementWeighted adds a data point of weight w.
+// Data points of non-positive weights are ignored.
+func (m *MeanVar) IncrementWeighted(v, w float64) {
+	if !(w > 0) {
+		return
+	}
+	m.N++
+	m.W += w
+	m.W2 += w * w
+	d := v - m.Mean
+	m.Mean += d * (w / m.W)
+	m.M2 += w * d * (v - m.Mean)
+}
+
+// Append merges another MeanVar.
+func (m *MeanVar) Append(m1 *MeanVar) {
+	if m1.N == 0 {
+		return
+	}
+	if m.N == 0 {
+		*m = *m1
+		return
+	}
+
+	wa, wb := m.W, m1.W
+	w := wa + wb
+	d := m1.Mean - m.Mean
+	m.Mean += d * (wb / w)
+	m.M2 += m1.M2 + d*d*wa*wb/w
+	m.N += m1.N
+	m.W = w
+	m.W2 += m1.W2
+}
+
+// GetMean returns the mean.
+func (m *MeanVar) GetMean() float64 {
+	if m.N == 0 {
+		return math.NaN()
+	}
+	return m.Mean
+}
+
+// GetEffectiveN returns the effective sample size of weighted data,
+// as Covariance.
+func (m *MeanVar) GetEffectiveN() float64 {
+	return m.W * m.W / m.W2
+}
+
+// GetVar returns the unbiased variance for reliability weights,
+// which is the sample variance if data are not weighted.
+func (m *MeanVar) GetVar() float64 {
+	return m.M2 / (m.W - m.W2/m.W)
+}
+
+// GetFreqVar returns the unbiased variance for frequency weights,
+// where a weight is the number of occurrences of a data point.
+func (m *MeanVar) GetFreqVar() float64 {
+	return m.M2 / (m.W - 1)
+}
@@ -1,20 +1,15 @@
 package calc
 
-import (
-	"github.com/mingzhi/gomath/stat/desc/meanvar"
-)
-
-
 // MeanVariances is an array of MeanVar.
 type MeanVariances struct {
-	meanvars []*meanvar.MeanVar
+	meanvars []*MeanVar
 }
 
 // NewMeanVariances return a new MeanVariances of length maxl.
 func NewMeanVariances(size int) *MeanVariances {
 	mcc := MeanVariances{}
 	for i := 0; i < size; i++ {
-		mv := meanvar.New()
+		mv := NewMeanVar()
 		mcc.meanvars = append(mcc.meanvars, mv)
 	}
 	return &mcc
@@ -25,19 +20,34 @@ func (m *MeanVariances) Increment(l int, v float64) {
 	m.meanvars[l].Increment(v)
 }
 
+// IncrementWeighted add a data point of weight w to the lst MeanVar.
+func (m *MeanVariances) IncrementWeighted(l int, v, w float64) {
+	m.meanvars[l].IncrementWeighted(v, w)
+}
+
 // GetMean returns the mean of the lst MeanVar.
 func (m *MeanVariances) GetMean(l int) float64 {
-	return m.meanvars[l].Mean.GetResult()
+	return m.meanvars[l].GetMean()
 }
 
 // GetVar returns the variance of the lst MeanVar.
 func (m *MeanVariances) GetVar(l int) float64 {
-	return m.meanvars[l].Var.GetResult()
+	return m.meanvars[l].GetVar()
+}
+
+// GetFreqVar returns the variance of the lst MeanVar for frequency weights.
+func (m *MeanVariances) GetFreqVar(l int) float64 {
+	return m.meanvars[l].GetFreqVar()
 }
 
 // GetN returns the size of the data points of the lst MeanVar.
 func (m *MeanVariances) GetN(l int) int {
-	return m.meanvars[l].Mean.GetN()
+	return m.meanvars[l].N
+}
+
+// GetEffectiveN returns the effective sample size of the lst MeanVar.
+func (m *MeanVariances) GetEffectiveN(l int) float64 {
+	return m.meanvars[l].GetEffectiveN()
 }
 
 func (m *MeanVariances) Size() int {
@@ -47,7 +57,6 @@ func (m *MeanVariances) Size() int {
 // Appends append a MeanVariances to the other
 func (m *MeanVariances) Append(m1 *MeanVariances) {
 	for i := 0; i < len(m.meanvars); i++ {
-		m.meanvars[i].Mean.Append(m1.meanvars[i].Mean)
-		m.meanvars[i].Var.Append(m1.meanvars[i].Var)
+		m.meanvars[i].Append(m1.meanvars[i])
 	}
 }
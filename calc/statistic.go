@@ -0,0 +1,227 @@
+package calc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SitePair contains comparisons of read pairs covering two sites.
+type SitePair struct {
+	Cov    *Covariance     // covariance of differences between read pairs at the two sites.
+	Table  *HaplotypeTable // two-site haplotypes, nil if not counted.
+	Weight float64         // weight of the site pair.
+}
+
+// Statistic accumulates a statistic of site pairs in bins of lags.
+// A statistic is saved with its calculator,
+// so it must be encodable by gob and JSON.
+type Statistic interface {
+	// Increment adds a site pair to bin i.
+	Increment(i int, p *SitePair)
+	// Append appends another statistic of the same kind.
+	Append(s Statistic)
+	// Result returns the statistic in bin i.
+	Result(i int) float64
+	// N returns the number of data points (site or read pairs) in bin i.
+	N(i int) int
+	// Var returns the sampling variance of the result in bin i.
+	Var(i int) float64
+}
+
+// Correlator is a covariance statistic,
+// which also has the correlation coefficient in each bin.
+type Correlator interface {
+	// Correlation returns the correlation in bin i.
+	Correlation(i int) float64
+	// CorrelationSE returns the standard error of the correlation in bin i.
+	CorrelationSE(i int) float64
+}
+
+// NewStatisticFunc returns a new statistic of n bins.
+type NewStatisticFunc func(n int) Statistic
+
+var statistics = map[string]NewStatisticFunc{}
+
+// Register makes a statistic available by name.
+// It panics if the name is registered twice.
+func Register(name string, fn NewStatisticFunc) {
+	if _, found := statistics[name]; found {
+		panic("calc: statistic registered twice: " + name)
+	}
+	statistics[name] = fn
+}
+
+// NewStatistic returns a new registered statistic of n bins.
+func NewStatistic(name string, n int) (Statistic, error) {
+	fn, found := statistics[name]
+	if !found {
+		return nil, fmt.Errorf("unknown statistic: %s", name)
+	}
+	return fn(n), nil
+}
+
+// Statistics returns names of registered statistics.
+func Statistics() []string {
+	var names []string
+	for name := range statistics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Cs, Cr and Ct are the default statistics,
+// and r^2, D' and sigma_d^2 are statistics of linkage disequilibrium.
+var (
+	DefaultStatistics = []string{"cs", "cr", "ct"}
+	LDStatistics      = []string{"r2", "dprime", "sigma_d2"}
+)
+
+func init() {
+	Register("cs", func(n int) Statistic { return &csStatistic{NewMeanVariances(n)} })
+	Register("cr", func(n int) Statistic { return &crStatistic{NewCovariances(n)} })
+	Register("ct", func(n int) Statistic { return &ctStatistic{NewCovariances(n)} })
+	Register("r2", func(n int) Statistic { return &r2Statistic{NewMeanVariances(n)} })
+	Register("dprime", func(n int) Statistic { return &dprimeStatistic{NewMeanVariances(n)} })
+	Register("sigma_d2", func(n int) Statistic { return &sigmaD2Statistic{NewCovariances(n)} })
+}
+
+// meanStatistic is the mean of values of site pairs.
+type meanStatistic struct {
+	Values *MeanVariances
+}
+
+func (s *meanStatistic) Result(i int) float64 { return s.Values.GetMean(i) }
+func (s *meanStatistic) N(i int) int          { return s.Values.GetN(i) }
+func (s *meanStatistic) Var(i int) float64 {
+	return s.Values.GetVar(i) / s.Values.GetEffectiveN(i)
+}
+
+// covStatistic is a covariance of values of site or read pairs.
+type covStatistic struct {
+	Covs *Covariances
+}
+
+func (s *covStatistic) Result(i int) float64 { return s.Covs.GetResult(i) }
+func (s *covStatistic) N(i int) int          { return s.Covs.GetN(i) }
+func (s *covStatistic) Var(i int) float64    { return s.Covs.GetSamplingVar(i) }
+
+func (s *covStatistic) Correlation(i int) float64   { return s.Covs.GetCorrelation(i) }
+func (s *covStatistic) CorrelationSE(i int) float64 { return s.Covs.GetCorrelationSE(i) }
+
+// csStatistic is Cs, the mean covariance of read pairs in site pairs.
+type csStatistic meanStatistic
+
+func (s *csStatistic) Increment(i int, p *SitePair) {
+	s.Values.IncrementWeighted(i, p.Cov.GetResult(), p.Weight)
+}
+
+func (s *csStatistic) Append(s1 Statistic)  { s.Values.Append(s1.(*csStatistic).Values) }
+func (s *csStatistic) Result(i int) float64 { return (*meanStatistic)(s).Result(i) }
+func (s *csStatistic) N(i int) int          { return (*meanStatistic)(s).N(i) }
+func (s *csStatistic) Var(i int) float64    { return (*meanStatistic)(s).Var(i) }
+
+// crStatistic is Cr, the covariance of diversities between site pairs.
+type crStatistic covStatistic
+
+func (s *crStatistic) Increment(i int, p *SitePair) {
+	s.Covs.IncrementWeighted(i, p.Cov.GetMeanX(), p.Cov.GetMeanY(), p.Weight)
+}
+
+func (s *crStatistic) Append(s1 Statistic)  { s.Covs.Append(s1.(*crStatistic).Covs) }
+func (s *crStatistic) Result(i int) float64 { return (*covStatistic)(s).Result(i) }
+func (s *crStatistic) N(i int) int          { return (*covStatistic)(s).N(i) }
+func (s *crStatistic) Var(i int) float64    { return (*covStatistic)(s).Var(i) }
+
+func (s *crStatistic) Correlation(i int) float64 {
+	return (*covStatistic)(s).Correlation(i)
+}
+
+func (s *crStatistic) CorrelationSE(i int) float64 {
+	return (*covStatistic)(s).CorrelationSE(i)
+}
+
+// ctStatistic is Ct, the covariance of all read pairs.
+type ctStatistic covStatistic
+
+func (s *ctStatistic) Increment(i int, p *SitePair) {
+	s.Covs.AppendAt(i, p.Cov)
+}
+
+func (s *ctStatistic) Append(s1 Statistic)  { s.Covs.Append(s1.(*ctStatistic).Covs) }
+func (s *ctStatistic) Result(i int) float64 { return (*covStatistic)(s).Result(i) }
+func (s *ctStatistic) N(i int) int          { return (*covStatistic)(s).N(i) }
+func (s *ctStatistic) Var(i int) float64    { return (*covStatistic)(s).Var(i) }
+
+func (s *ctStatistic) Correlation(i int) float64 {
+	return (*covStatistic)(s).Correlation(i)
+}
+
+func (s *ctStatistic) CorrelationSE(i int) float64 {
+	return (*covStatistic)(s).CorrelationSE(i)
+}
+
+// r2Statistic is the mean r^2 of polymorphic site pairs.
+type r2Statistic meanStatistic
+
+func (s *r2Statistic) Increment(i int, p *SitePair) {
+	if p.Table == nil {
+		return
+	}
+	if d, hz, ok := p.Table.D(); ok {
+		s.Values.Increment(i, d*d/hz)
+	}
+}
+
+func (s *r2Statistic) Append(s1 Statistic)  { s.Values.Append(s1.(*r2Statistic).Values) }
+func (s *r2Statistic) Result(i int) float64 { return (*meanStatistic)(s).Result(i) }
+func (s *r2Statistic) N(i int) int          { return (*meanStatistic)(s).N(i) }
+func (s *r2Statistic) Var(i int) float64    { return (*meanStatistic)(s).Var(i) }
+
+// dprimeStatistic is the mean D' of polymorphic site pairs.
+type dprimeStatistic meanStatistic
+
+func (s *dprimeStatistic) Increment(i int, p *SitePair) {
+	if p.Table == nil {
+		return
+	}
+	if _, _, ok := p.Table.D(); ok {
+		s.Values.Increment(i, p.Table.DPrime())
+	}
+}
+
+func (s *dprimeStatistic) Append(s1 Statistic)  { s.Values.Append(s1.(*dprimeStatistic).Values) }
+func (s *dprimeStatistic) Result(i int) float64 { return (*meanStatistic)(s).Result(i) }
+func (s *dprimeStatistic) N(i int) int          { return (*meanStatistic)(s).N(i) }
+func (s *dprimeStatistic) Var(i int) float64    { return (*meanStatistic)(s).Var(i) }
+
+// sigmaD2Statistic is sigma_d^2 (Ohta and Kimura 1969),
+// the ratio of expectations E[D^2] / E[pA pa pB pb] of polymorphic site pairs,
+// which weights site pairs by their diversity
+// instead of averaging ratios of uneven samples.
+// It accumulates D^2 (x) and pA pa pB pb (y).
+type sigmaD2Statistic covStatistic
+
+func (s *sigmaD2Statistic) Increment(i int, p *SitePair) {
+	if p.Table == nil {
+		return
+	}
+	if d, hz, ok := p.Table.D(); ok {
+		s.Covs.Increment(i, d*d, hz)
+	}
+}
+
+func (s *sigmaD2Statistic) Append(s1 Statistic) { s.Covs.Append(s1.(*sigmaD2Statistic).Covs) }
+func (s *sigmaD2Statistic) N(i int) int         { return s.Covs.GetN(i) }
+
+func (s *sigmaD2Statistic) Result(i int) float64 {
+	return s.Covs.GetMeanX(i) / s.Covs.GetMeanY(i)
+}
+
+// Var returns the sampling variance of the ratio by the delta method.
+func (s *sigmaD2Statistic) Var(i int) float64 {
+	r := s.Result(i)
+	my := s.Covs.GetMeanY(i)
+	v := s.Covs.GetVarX(i) - 2*r*s.Covs.GetResult(i) + r*r*s.Covs.GetVarY(i)
+	return v / (s.Covs.GetEffectiveN(i) * my * my)
+}
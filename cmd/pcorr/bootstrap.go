@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// bootstrapper resamples units (chunks, genes or blocks of sites) with replacement,
+// and returns percentile confidence intervals.
+type bootstrapper struct {
+	replicates int
+	level      float64 // confidence level, e.g. 0.95.
+	rng        *rand.Rand
+}
+
+func newBootstrapper(replicates int, seed int64) *bootstrapper {
+	b := bootstrapper{replicates: replicates, level: 0.95}
+	b.rng = rand.New(rand.NewSource(seed))
+	return &b
+}
+
+// MeanCI returns confidence intervals of per-lag (weighted) means over units,
+// where units[i][l] is the value of the ith unit at lag l,
+// weighted by weights[i][l] if weights are given,
+// and NaN values are missing.
+func (b *bootstrapper) MeanCI(units, weights [][]float64, size int) (lo, hi []float64) {
+	reps := make([][]float64, size)
+	sums := make([]float64, size)
+	totals := make([]float64, size)
+	for r := 0; r < b.replicates && len(units) > 0; r++ {
+		for l := range sums {
+			sums[l], totals[l] = 0, 0
+		}
+		for i := 0; i < len(units); i++ {
+			k := b.rng.Intn(len(units))
+			for l, v := range units[k] {
+				w := 1.0
+				if weights != nil {
+					w = weights[k][l]
+				}
+				if !math.IsNaN(v) && w > 0 {
+					sums[l] += w * v
+					totals[l] += w
+				}
+			}
+		}
+		for l := range sums {
+			if totals[l] > 0 {
+				reps[l] = append(reps[l], sums[l]/totals[l])
+			}
+		}
+	}
+
+	lo = make([]float64, size)
+	hi = make([]float64, size)
+	for l := range reps {
+		lo[l], hi[l] = b.percentiles(reps[l])
+	}
+	return
+}
+
+// Resample calls fn with replicates of indices drawn from n units.
+func (b *bootstrapper) Resample(n int, fn func(indices []int)) {
+	indices := make([]int, n)
+	for r := 0; r < b.replicates; r++ {
+		for i := range indices {
+			indices[i] = b.rng.Intn(n)
+		}
+		fn(indices)
+	}
+}
+
+// percentiles returns the lower and upper percentiles of replicates
+// at the confidence level.
+func (b *bootstrapper) percentiles(values []float64) (lo, hi float64) {
+	if len(values) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	alpha := (1 - b.level) / 2
+	lo = sorted[int(alpha*float64(len(sorted)-1)+0.5)]
+	hi = sorted[int((1-alpha)*float64(len(sorted)-1)+0.5)]
+	return
+}
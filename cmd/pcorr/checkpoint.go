@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mingzhi/pileup/calc"
+)
+
+// ctCheckpoint contains the state of a ct run:
+// values of completed chuncks of each reference,
+// and where to continue in the reference in progress.
+type ctCheckpoint struct {
+	Params *ctParams
+	Refs   []*ctRefState
+}
+
+// ctParams are parameters of a ct run,
+// which must be the same when resuming from its checkpoint.
+type ctParams struct {
+	Bins        *calc.Bins
+	Stats       []string
+	LD          bool
+	Method      string
+	Weighting   string
+	ChunckSize  int
+	RegionStart int
+	Sites       string
+	MinCoverage int
+	Estimator   string
+	Alpha       float64
+	MaxPairs    int
+	Seed        int64
+	RegionEnd   int
+	Format      string // pileup format.
+}
+
+// params returns parameters of the run.
+func (cmd *cmdCt) params() *ctParams {
+	return &ctParams{
+		Bins:        cmd.bins,
+		Stats:       cmd.stats,
+		LD:          cmd.ldFile != "",
+		Method:      cmd.method,
+		Weighting:   cmd.weighting,
+		ChunckSize:  cmd.chunckSize,
+		RegionStart: cmd.regionStart,
+		Sites:       cmd.sites.String(),
+		MinCoverage: cmd.minCoverage,
+		Estimator:   cmd.errModel.estimator,
+		Alpha:       cmd.errModel.alpha,
+		MaxPairs:    cmd.maxPairs,
+		Seed:        cmd.seed,
+		RegionEnd:   cmd.regionEnd,
+		Format:      cmd.pileupFormat,
+	}
+}
+
+// mismatch returns the name of the first parameter different from p1,
+// or an empty string if all parameters are the same.
+func (p *ctParams) mismatch(p1 *ctParams) string {
+	switch {
+	case !p.Bins.Equal(p1.Bins):
+		return "bins"
+	case strings.Join(p.Stats, ",") != strings.Join(p1.Stats, ","):
+		return "stats"
+	case p.LD != p1.LD:
+		return "ld"
+	case p.Method != p1.Method:
+		return "method"
+	case p.Weighting != p1.Weighting:
+		return "weighting"
+	case p.ChunckSize != p1.ChunckSize:
+		return "chunck size"
+	case p.RegionStart != p1.RegionStart:
+		return "region start"
+	case p.Sites != p1.Sites:
+		return "sites"
+	case p.MinCoverage != p1.MinCoverage:
+		return "min coverage"
+	case p.Estimator != p1.Estimator:
+		return "estimator"
+	case p.Alpha != p1.Alpha:
+		return "alpha"
+	case p.MaxPairs != p1.MaxPairs:
+		return "max pairs"
+	case p.Seed != p1.Seed:
+		return "seed"
+	case p.RegionEnd != p1.RegionEnd:
+		return "region end"
+	case p.Format != p1.Format:
+		return "pileup format"
+	}
+	return ""
+}
+
+// ctRefState is the state of a reference.
+type ctRefState struct {
+	Genome     string
+	Chuncks    [][][]float64 // values of completed chuncks.
+	Weights    [][][]float64
+	LDChuncks  [][][]float64
+	Calculator *calc.Calculator // completed chuncks appended.
+	LastPos    int              // position of the last SNP in completed chuncks.
+	NextEnd    int              // end of the next chunck.
+	Done       bool
+}
+
+// ref returns the state of a reference,
+// which is added if it is not in the checkpoint.
+func (c *ctCheckpoint) ref(genome string, chunckEnd int) *ctRefState {
+	for _, s := range c.Refs {
+		if s.Genome == genome {
+			return s
+		}
+	}
+	s := &ctRefState{Genome: genome, LastPos: -1, NextEnd: chunckEnd}
+	c.Refs = append(c.Refs, s)
+	return s
+}
+
+// loadCheckpoint reads a checkpoint file,
+// of a run of the same parameters.
+func loadCheckpoint(filename string, params *ctParams) *ctCheckpoint {
+	f := openFile(filename)
+	defer f.Close()
+
+	var c ctCheckpoint
+	if err := gob.NewDecoder(f).Decode(&c); err != nil {
+		log.Fatalf("Can not read checkpoint %s: %v\n", filename, err)
+	}
+	if c.Params == nil {
+		log.Fatalf("Checkpoint %s has no run parameters.\n", filename)
+	}
+	if name := params.mismatch(c.Params); name != "" {
+		log.Fatalf("Can not resume from checkpoint %s of different %s.\n", filename, name)
+	}
+	return &c
+}
+
+// save writes the checkpoint to a temporary file,
+// and renames it to the checkpoint file,
+// so that a killed run never leaves a partial checkpoint.
+func (c *ctCheckpoint) save(filename string) {
+	tmpFile := filename + ".tmp"
+	f := createFile(tmpFile)
+	if err := gob.NewEncoder(f).Encode(c); err != nil {
+		log.Fatalf("Can not write checkpoint %s: %v\n", tmpFile, err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmpFile, filename); err != nil {
+		log.Fatalln(err)
+	}
+}
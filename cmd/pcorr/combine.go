@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingzhi/pileup/calc"
+)
+
+// ctSaved contains results of a ct run,
+// which are combined with other runs by pcorr combine.
+type ctSaved struct {
+	Calculator *calc.Calculator      // all chuncks appended.
+	Stats      []string              // names of statistics of profiles.
+	Weighting  string                // weighting of chuncks in profiles.
+	Profiles   []*calc.MeanVariances // statistics of chuncks.
+	LDProfiles []*calc.MeanVariances // r^2, D' and sigma_d^2 of chuncks, if any.
+}
+
+// Append appends another saved result.
+func (s *ctSaved) Append(s1 *ctSaved) {
+	s.Calculator.Append(s1.Calculator)
+	for i := range s.Profiles {
+		s.Profiles[i].Append(s1.Profiles[i])
+	}
+	if len(s.LDProfiles) != len(s1.LDProfiles) {
+		s.LDProfiles = nil
+	}
+	for i := range s.LDProfiles {
+		s.LDProfiles[i].Append(s1.LDProfiles[i])
+	}
+}
+
+// saveCt writes a saved result in JSON if the file name ends with .json,
+// or in binary.
+func saveCt(filename string, s *ctSaved) {
+	w := createFile(filename)
+	defer w.Close()
+
+	var err error
+	if filepath.Ext(filename) == ".json" {
+		err = json.NewEncoder(w).Encode(s)
+	} else {
+		err = gob.NewEncoder(w).Encode(s)
+	}
+	if err != nil {
+		log.Fatalf("Can not save %s: %v\n", filename, err)
+	}
+}
+
+// loadCt reads a saved result.
+func loadCt(filename string) *ctSaved {
+	f := openFile(filename)
+	defer f.Close()
+
+	var s ctSaved
+	var err error
+	if filepath.Ext(filename) == ".json" {
+		err = json.NewDecoder(f).Decode(&s)
+	} else {
+		err = gob.NewDecoder(f).Decode(&s)
+	}
+	if err != nil {
+		log.Fatalf("Can not load %s: %v\n", filename, err)
+	}
+	return &s
+}
+
+type cmdCombine struct {
+	inFiles                   []string
+	outFile, ldFile, saveFile string
+	corr                      bool
+}
+
+// Run is the main function.
+func (cmd *cmdCombine) Run() {
+	if len(cmd.inFiles) == 0 {
+		log.Fatalln("No saved results to combine.")
+	}
+
+	var combined *ctSaved
+	for _, filename := range cmd.inFiles {
+		s := loadCt(filename)
+		if combined == nil {
+			combined = s
+		} else if !s.Calculator.Bins.Equal(combined.Calculator.Bins) {
+			log.Fatalf("%s has different bins of lags.\n", filename)
+		} else if strings.Join(s.Stats, ",") != strings.Join(combined.Stats, ",") {
+			log.Fatalf("%s has different statistics.\n", filename)
+		} else if s.Calculator.PairWeighted != combined.Calculator.PairWeighted || s.Weighting != combined.Weighting {
+			log.Fatalf("%s has different weighting of site pairs.\n", filename)
+		} else {
+			combined.Append(s)
+		}
+	}
+
+	ct := cmdCt{corr: cmd.corr}
+	ct.setBins(combined.Calculator.Bins)
+	ct.write(&ctCollector{names: combined.Stats, meanvars: combined.Profiles, calculator: combined.Calculator}, cmd.outFile)
+	if cmd.ldFile != "" {
+		if combined.LDProfiles == nil {
+			log.Fatalln("Saved results have no LD.")
+		}
+		ct.write(&ctCollector{names: calc.LDStatistics, meanvars: combined.LDProfiles}, cmd.ldFile)
+	}
+
+	if cmd.saveFile != "" {
+		saveCt(cmd.saveFile, combined)
+	}
+}
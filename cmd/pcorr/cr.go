@@ -2,12 +2,11 @@ package main
 
 import (
 	"fmt"
-	"github.com/mingzhi/gomath/stat/correlation"
-	"github.com/mingzhi/gomath/stat/desc/meanvar"
-	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/biogo/feat/gff"
 	"github.com/mingzhi/ncbiftp/taxonomy"
+	"github.com/mingzhi/pileup/calc"
+	"log"
 	"math"
-	"path/filepath"
 )
 
 type cmdCr struct {
@@ -15,59 +14,147 @@ type cmdCr struct {
 	codonTableID                       string
 	maxl, pos, minCoverage             int
 	regionStart, regionEnd, chunckSize int
+	sites                              *siteSelector
+	errModel                           errorModel
+	rarefier                           *rarefier
+	bootstrapper                       *bootstrapper
+	by                                 string // group sites by chuncks or genes.
+	weighting                          string
+	corr                               bool // write correlations.
 }
 
 func (cmd *cmdCr) Run() {
+	if cmd.by != byChunck && cmd.by != byGene {
+		log.Fatalf("Can not recognize the grouping: %s\n", cmd.by)
+	}
+	checkWeighting(cmd.weighting)
+
 	// Read pi.
 	piFile := cmd.prefix + ".pi"
 	piChan := readPi(piFile)
 	gPiCC := cmd.separate(piChan)
+	genomes := newGenomeSource(cmd.genomeDir, cmd.genomeDir)
 	for gPiChan := range gPiCC {
-		cmd.runOne(gPiChan)
+		cmd.runOne(gPiChan, genomes)
 	}
 }
 
-func (cmd *cmdCr) runOne(gPiChan genomePiChan) {
+func (cmd *cmdCr) runOne(gPiChan genomePiChan, genomes *genomeSource) {
 	// Obtain codon table for identifying four-fold degenerate sites.
 	codonTable := taxonomy.GeneticCodes()[cmd.codonTableID]
 	// Profiling genome using reference sequence and protein feature data.
 	ref := gPiChan.genome
-	fnaFile := filepath.Join(cmd.genomeDir, ref+".fna")
-	gffFile := filepath.Join(cmd.genomeDir, ref+".gff")
-	gffs := readGff(gffFile)
-	genome := readGenome(fnaFile)
-	profile := profiling.ProfileGenome(genome, gffs, codonTable)
-	posType := convertPosType(cmd.pos)
-	piChunckChan := cmd.split(gPiChan.piChan)
-	covsChan := cmd.calc(piChunckChan, profile, posType, cmd.maxl)
-	covMVs, xMVs, yMVs := cmd.collect(covsChan)
-
-	cmd.write(ref, covMVs, xMVs, yMVs)
+	profile := genomes.Profile(ref, codonTable)
+	if profile == nil {
+		log.Printf("Can not find the genome of %s, skip it.\n", ref)
+		for range gPiChan.piChan {
+		}
+		return
+	}
+	selectSite := cmd.sites.Bind(ref, profile, genomes.Gffs(ref))
+	piChan := gPiChan.piChan
+	if cmd.rarefier != nil {
+		piChan = cmd.rarefy(piChan)
+	}
+
+	if cmd.by == byGene {
+		cmd.runGenes(ref, piChan, genomes.Gffs(ref), selectSite)
+		return
+	}
+
+	piChunckChan := cmd.split(piChan)
+	covsChan := cmd.calc(piChunckChan, selectSite, cmd.maxl)
+	covMVs, xMVs, yMVs, pooled, chuncks, weights := cmd.collect(covsChan)
+
+	// Bootstrap chuncks for confidence intervals.
+	var lo, hi []float64
+	if cmd.bootstrapper != nil {
+		lo, hi = cmd.bootstrapper.MeanCI(chuncks, weights, cmd.maxl)
+	}
+
+	cmd.write(ref, covMVs, xMVs, yMVs, pooled, lo, hi)
 }
 
-func (cmd *cmdCr) collect(covsChan chan []Covariance) (covMVs, xMVs, yMVs []*meanvar.MeanVar) {
-	covMVs = make([]*meanvar.MeanVar, cmd.maxl)
-	xMVs = make([]*meanvar.MeanVar, cmd.maxl)
-	yMVs = make([]*meanvar.MeanVar, cmd.maxl)
+// collect collects covariances of chuncks, weighted by the weighting,
+// and returns covariances of all chuncks appended,
+// and chunck values and weights for bootstrapping.
+func (cmd *cmdCr) collect(covsChan chan *calc.Covariances) (covMVs, xMVs, yMVs []*calc.MeanVar, pooled *calc.Covariances, chuncks, weights [][]float64) {
+	covMVs = make([]*calc.MeanVar, cmd.maxl)
+	xMVs = make([]*calc.MeanVar, cmd.maxl)
+	yMVs = make([]*calc.MeanVar, cmd.maxl)
 	for i := range covMVs {
-		covMVs[i] = meanvar.New()
-		xMVs[i] = meanvar.New()
-		yMVs[i] = meanvar.New()
+		covMVs[i] = calc.NewMeanVar()
+		xMVs[i] = calc.NewMeanVar()
+		yMVs[i] = calc.NewMeanVar()
 	}
+	pooled = calc.NewCovariances(cmd.maxl)
 	for covs := range covsChan {
-		for i := range covs {
-			n := covs[i].GetN()
-			v := covs[i].GetResult()
+		pooled.Append(covs)
+		values := make([]float64, cmd.maxl)
+		ws := make([]float64, cmd.maxl)
+		for i := range values {
+			values[i] = math.NaN()
+			n := covs.GetN(i)
+			v := covs.GetResult(i)
 			if n > 10 && !math.IsNaN(v) {
-				covMVs[i].Increment(v)
-				xMVs[i].Increment(covs[i].MeanX())
-				yMVs[i].Increment(covs[i].MeanY())
+				w := weightOf(cmd.weighting, n, covs.GetSamplingVar(i))
+				covMVs[i].IncrementWeighted(v, w)
+				xMVs[i].IncrementWeighted(covs.GetMeanX(i), w)
+				yMVs[i].IncrementWeighted(covs.GetMeanY(i), w)
+				values[i] = v
+				ws[i] = w
 			}
 		}
+
+		// keep chunck values for bootstrapping.
+		if cmd.bootstrapper != nil {
+			chuncks = append(chuncks, values)
+			weights = append(weights, ws)
+		}
 	}
 	return
 }
 
+// runGenes calculates covariances of rates in each gene of a genome,
+// and writes them in a long table.
+func (cmd *cmdCr) runGenes(ref string, piChan chan Pi, gffs []*gff.Record, selectSite func(pos int) bool) {
+	outFile := fmt.Sprintf("%s_%s_calc_cr_%d_gene.txt", cmd.prefix, ref, cmd.pos)
+	w := createFile(outFile)
+	defer w.Close()
+	if cmd.corr {
+		w.WriteString("gene_id\tlag\tcr\tpi_x\tpi_y\tn\tcorr\tcorr_se\n")
+	} else {
+		w.WriteString("gene_id\tlag\tcr\tpi_x\tpi_y\tn\n")
+	}
+
+	writeGene := func(gene *geneGroup) {
+		pis := make([]Pi, len(gene.items))
+		for i, item := range gene.items {
+			pis[i] = item.(Pi)
+		}
+		covs := cmd.calcCr(pis, selectSite, cmd.maxl)
+		for l := 0; l < cmd.maxl; l++ {
+			if n := covs.GetN(l); n > 0 {
+				w.WriteString(fmt.Sprintf("%s\t%d\t%g\t%g\t%g\t%d", gene.id, l, covs.GetResult(l), covs.GetMeanX(l), covs.GetMeanY(l), n))
+				if cmd.corr {
+					w.WriteString(fmt.Sprintf("\t%g\t%g", covs.GetCorrelation(l), covs.GetCorrelationSE(l)))
+				}
+				w.WriteString("\n")
+			}
+		}
+	}
+
+	grouper := newGeneGrouper(gffs)
+	for pi := range piChan {
+		for _, gene := range grouper.Add(pi.Pos, pi) {
+			writeGene(gene)
+		}
+	}
+	for _, gene := range grouper.Flush() {
+		writeGene(gene)
+	}
+}
+
 type genomePiChan struct {
 	genome string
 	piChan chan Pi
@@ -77,27 +164,40 @@ func (cmd *cmdCr) separate(piChan chan Pi) chan genomePiChan {
 	cc := make(chan genomePiChan)
 	go func() {
 		defer close(cc)
-		c := genomePiChan{}
+		var c genomePiChan
 		for pi := range piChan {
-			if c.genome == "" {
-				c.genome = pi.Ref
-				c.piChan = make(chan Pi)
-			}
-
-			if c.genome != pi.Ref {
+			if c.piChan == nil || c.genome != pi.Ref {
+				if c.piChan != nil {
+					close(c.piChan)
+				}
+				c = genomePiChan{genome: pi.Ref, piChan: make(chan Pi)}
 				cc <- c
-				c = genomePiChan{}
-				c.genome = pi.Ref
-				c.piChan = make(chan Pi)
 			}
 
 			c.piChan <- pi
 		}
-		cc <- c
+		if c.piChan != nil {
+			close(c.piChan)
+		}
 	}()
 	return cc
 }
 
+// rarefy brings sites to the rarefied depth,
+// and skips sites with less coverage.
+func (cmd *cmdCr) rarefy(piChan chan Pi) chan Pi {
+	c := make(chan Pi)
+	go func() {
+		defer close(c)
+		for pi := range piChan {
+			if pi, ok := cmd.rarefier.Rarefy(pi); ok {
+				c <- pi
+			}
+		}
+	}()
+	return c
+}
+
 func (cmd *cmdCr) split(piChan chan Pi) chan []Pi {
 	c := make(chan []Pi)
 	go func() {
@@ -117,12 +217,12 @@ func (cmd *cmdCr) split(piChan chan Pi) chan []Pi {
 	return c
 }
 
-func (cmd *cmdCr) calc(piChunckChan chan []Pi, profile []profiling.Pos, posType byte, maxl int) chan []Covariance {
-	c := make(chan []Covariance)
+func (cmd *cmdCr) calc(piChunckChan chan []Pi, selectSite func(pos int) bool, maxl int) chan *calc.Covariances {
+	c := make(chan *calc.Covariances)
 	go func() {
 		defer close(c)
 		for chunck := range piChunckChan {
-			covs := cmd.calcCr(chunck, profile, posType, maxl)
+			covs := cmd.calcCr(chunck, selectSite, maxl)
 			c <- covs
 		}
 	}()
@@ -130,28 +230,15 @@ func (cmd *cmdCr) calc(piChunckChan chan []Pi, profile []profiling.Pos, posType
 	return c
 }
 
-type Covariance interface {
-	GetN() int
-	GetResult() float64
-	MeanX() float64
-	MeanY() float64
-	Increment(x, y float64)
-}
-
 // Calculate covariance of rates.
-func (cmd *cmdCr) calcCr(pis []Pi, profile []profiling.Pos, posType byte, maxl int) (covs []Covariance) {
-	corrs := make([]Covariance, maxl)
-	for i := 0; i < maxl; i++ {
-		corrs[i] = correlation.NewBivariateCovariance(false)
-	}
+func (cmd *cmdCr) calcCr(pis []Pi, selectSite func(pos int) bool, maxl int) (covs *calc.Covariances) {
+	covs = calc.NewCovariances(maxl)
 
 	for i := 0; i < len(pis); i++ {
 		p1 := pis[i]
-		pos1 := profile[p1.Pos]
-		if checkPosType(posType, pos1.Type) {
+		if selectSite(p1.Pos) {
 			for j := i; j < len(pis); j++ {
 				p2 := pis[j]
-				pos2 := profile[p2.Pos]
 
 				distance := p2.Pos - p1.Pos
 				if distance < 0 {
@@ -161,20 +248,22 @@ func (cmd *cmdCr) calcCr(pis []Pi, profile []profiling.Pos, posType byte, maxl i
 					break
 				}
 
-				if checkPosType(posType, pos2.Type) {
-					x, y := p1.Pi(), p2.Pi()
-					corrs[distance].Increment(x, y)
+				if selectSite(p2.Pos) {
+					x, y := p1.Estimate(cmd.errModel), p2.Estimate(cmd.errModel)
+					covs.Increment(distance, x, y)
 				}
 			}
 		}
 
 	}
 
-	covs = corrs
 	return
 }
 
-func (cmd *cmdCr) write(ref string, covMVs, xMVs, yMVs []*meanvar.MeanVar) {
+// write writes results of a genome,
+// followed by confidence intervals of the covariance if they are given,
+// and the correlation and its standard error of pooled chuncks if wanted.
+func (cmd *cmdCr) write(ref string, covMVs, xMVs, yMVs []*calc.MeanVar, pooled *calc.Covariances, lo, hi []float64) {
 	outFile := fmt.Sprintf("%s_%s_calc_cr_%d.txt", cmd.prefix, ref, cmd.pos)
 	w := createFile(outFile)
 	defer w.Close()
@@ -183,6 +272,13 @@ func (cmd *cmdCr) write(ref string, covMVs, xMVs, yMVs []*meanvar.MeanVar) {
 		c := covMVs[i]
 		x := xMVs[i]
 		y := yMVs[i]
-		w.WriteString(fmt.Sprintf("%d\t%g\t%g\t%g\t%g\t%g\t%g\t%d\n", i, c.Mean.GetResult(), c.Var.GetResult(), x.Mean.GetResult(), x.Var.GetResult(), y.Mean.GetResult(), y.Var.GetResult(), c.Mean.GetN()))
+		w.WriteString(fmt.Sprintf("%d\t%g\t%g\t%g\t%g\t%g\t%g\t%d", i, c.GetMean(), c.GetVar(), x.GetMean(), x.GetVar(), y.GetMean(), y.GetVar(), c.N))
+		if lo != nil {
+			w.WriteString(fmt.Sprintf("\t%g\t%g", lo[i], hi[i]))
+		}
+		if cmd.corr {
+			w.WriteString(fmt.Sprintf("\t%g\t%g", pooled.GetCorrelation(i), pooled.GetCorrelationSE(i)))
+		}
+		w.WriteString("\n")
 	}
 }
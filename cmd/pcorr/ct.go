@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"os"
-	"runtime"
+	"strconv"
+	"strings"
 
+	"github.com/mingzhi/biogo/feat/gff"
 	"github.com/mingzhi/ncbiftp/genomes/profiling"
 	"github.com/mingzhi/ncbiftp/taxonomy"
 	"github.com/mingzhi/pileup"
@@ -15,15 +18,64 @@ import (
 
 type cmdCt struct {
 	pileupFile, fastaFile, gffFile, outFile string
+	ldFile                                  string
+	saveFile                                string
+	checkpointFile                          string
+	checkpointInterval                      int // in chuncks.
+	resume                                  bool
+	by                                      string // group sites by chuncks or genes.
+	weighting                               string
+	stats                                   []string // names of statistics.
+	corr                                    bool     // write correlations of covariance statistics.
+	bins                                    *calc.Bins
+	binned                                  bool // whether bins are not single lags.
 	pileupFormat                            string
 	codonTableID                            string
 	maxl, pos, minCoverage                  int
 	regionStart, regionEnd, chunckSize      int
+	method                                  string
+	maxPairs                                int // max read pairs compared per SNP pair, 0 for all.
+	seed                                    int64
+	sites                                   *siteSelector
+	errModel                                errorModel
+	bootstrapper                            *bootstrapper
+	pool                                    *ctPool // workers of the run.
 	debug                                   bool
 }
 
+// Methods of comparing read pairs:
+// counting two-site haplotypes, which is exact and O(n),
+// or comparing (sampled) read pairs one by one.
+const (
+	tableMethod = "table"
+	pairsMethod = "pairs"
+)
+
 // Run is the main function.
 func (cmd *cmdCt) Run() {
+	if cmd.method != tableMethod && cmd.method != pairsMethod {
+		log.Fatalf("Can not recognize the method: %s\n", cmd.method)
+	}
+	if cmd.resume && cmd.checkpointFile == "" {
+		log.Fatalln("Resuming requires a checkpoint file.")
+	}
+	checkWeighting(cmd.weighting)
+	if len(cmd.stats) == 0 {
+		cmd.stats = calc.DefaultStatistics
+	}
+	if cmd.bins == nil {
+		cmd.setBins(calc.NewLinearBins(cmd.maxl))
+	}
+	if cmd.by != byChunck && cmd.by != byGene {
+		log.Fatalf("Can not recognize the grouping: %s\n", cmd.by)
+	}
+	if cmd.by == byGene && (cmd.checkpointFile != "" || cmd.ldFile != "" || cmd.saveFile != "") {
+		log.Fatalln("Checkpoints, LD and saving are not supported by gene.")
+	}
+	if _, err := calc.NewWithStatistics(cmd.bins, cmd.stats...); err != nil {
+		log.Fatalln(err)
+	}
+
 	// The input of pileup can be from standard input,
 	// or from a file.
 	var f *os.File
@@ -34,46 +86,285 @@ func (cmd *cmdCt) Run() {
 	}
 	defer f.Close()
 
-	// Prepare genome position profile.
-	genome := readGenome(cmd.fastaFile)
-	gffs := readGff(cmd.gffFile)
+	// Prepare genomes for position profiles.
+	genomes := newGenomeSource(cmd.fastaFile, cmd.gffFile)
 	codonTable := taxonomy.GeneticCodes()[cmd.codonTableID]
-	profile := profiling.ProfileGenome(genome, gffs, codonTable)
-	if cmd.regionEnd <= 0 {
-		cmd.regionEnd = len(profile)
+
+	// Read SNP from pileup input,
+	// and separate them by references.
+	snpChan := readPileup(f, 0, 0, cmd.pileupFormat)
+	genomeSNPChanChan := cmd.separate(snpChan)
+
+	// Workers calculate SNPs of all genomes.
+	cmd.pool = cmd.newPool()
+	defer cmd.pool.Close()
+
+	if cmd.by == byGene {
+		cmd.runGenes(genomeSNPChanChan, genomes, codonTable)
+		return
+	}
+
+	// Continue from the checkpoint,
+	// or start a new one.
+	checkpoint := &ctCheckpoint{Params: cmd.params()}
+	if cmd.resume {
+		checkpoint = loadCheckpoint(cmd.checkpointFile, checkpoint.Params)
+	}
+
+	pooled := cmd.newCollector(cmd.stats)
+	pooledLD := cmd.newCollector(calc.LDStatistics)
+	pooledCalculator := cmd.newCalculator()
+	seen := make(map[string]bool) // references added to pooled results.
+	for g := range genomeSNPChanChan {
+		// A finished reference is not calculated again,
+		// but its SNPs are still read from the pileup.
+		if seen[g.genome] {
+			log.Printf("%s appears again in the pileup, skip it.\n", g.genome)
+			for range g.snpChan {
+			}
+			continue
+		}
+		seen[g.genome] = true
+
+		state := checkpoint.ref(g.genome, cmd.chunckSize+cmd.regionStart)
+		var profile []profiling.Pos
+		if !state.Done {
+			profile = genomes.Profile(g.genome, codonTable)
+			if profile == nil {
+				log.Printf("Can not find the genome of %s, skip it.\n", g.genome)
+			}
+		}
+		if profile == nil {
+			for range g.snpChan {
+			}
+		} else {
+			cmd.calcGenome(g, profile, genomes.Gffs(g.genome), state, checkpoint)
+		}
+
+		// Collect results from each chunck,
+		// for the genome and for all genomes.
+		collector := cmd.newCollector(cmd.stats)
+		collector.calculator = state.Calculator
+		ldCollector := cmd.newCollector(calc.LDStatistics)
+		for i := range state.Chuncks {
+			collector.Add(state.Chuncks[i], state.Weights[i])
+			pooled.Add(state.Chuncks[i], state.Weights[i])
+		}
+		for i := range state.LDChuncks {
+			ldCollector.Add(state.LDChuncks[i], nil)
+			pooledLD.Add(state.LDChuncks[i], nil)
+		}
+
+		if state.Done {
+			cmd.write(collector, refOutFile(cmd.outFile, g.genome))
+			if cmd.ldFile != "" {
+				cmd.write(ldCollector, refOutFile(cmd.ldFile, g.genome))
+			}
+		}
+
+		if state.Calculator != nil {
+			pooledCalculator.Append(state.Calculator)
+		}
+
+		// Without a checkpoint, chunck values are no longer needed.
+		if cmd.checkpointFile == "" {
+			state.Chuncks, state.Weights, state.LDChuncks = nil, nil, nil
+		}
 	}
 
-	// Convert pos from int to byte.
-	posType := convertPosType(cmd.pos)
+	// And finally, write pooled results into the output file.
+	pooled.calculator = pooledCalculator
+	cmd.write(pooled, cmd.outFile)
+	if cmd.ldFile != "" {
+		cmd.write(pooledLD, cmd.ldFile)
+	}
 
-	// Read SNP from pileup input.
-	snpChan := readPileup(f, cmd.regionStart, cmd.regionEnd, cmd.pileupFormat)
+	// Save results for combining with other runs.
+	if cmd.saveFile != "" {
+		saved := ctSaved{Calculator: pooledCalculator, Stats: cmd.stats, Weighting: cmd.weighting, Profiles: pooled.meanvars}
+		if cmd.ldFile != "" {
+			saved.LDProfiles = pooledLD.meanvars
+		}
+		saveCt(cmd.saveFile, &saved)
+	}
+}
 
+// calcGenome calculates correlations in chuncks of a genome,
+// continuing from its state,
+// and saves the checkpoint every checkpointInterval chuncks.
+func (cmd *cmdCt) calcGenome(g genomeSNPChan, profile []profiling.Pos, gffs []*gff.Record, state *ctRefState, checkpoint *ctCheckpoint) {
 	// Apply filters.
-	filteredSNPChan := cmd.filterSNP(snpChan, profile, posType)
+	selectSite := cmd.sites.Bind(g.genome, profile, gffs)
+	filteredSNPChan := cmd.filterSNP(g.snpChan, len(profile), selectSite)
+
+	// Skip SNPs of completed chuncks.
+	skippedSNPChan := make(chan *pileup.SNP)
+	go func() {
+		defer close(skippedSNPChan)
+		for s := range filteredSNPChan {
+			if s.Pos > state.LastPos {
+				skippedSNPChan <- s
+			}
+		}
+	}()
+
+	// Submit SNPs to workers,
+	// and receive chuncks in order when they are calculated.
+	chunckChan := cmd.pool.Submit(skippedSNPChan, state.NextEnd, cmd.chunckSize)
+
+	numChuncks := 0
+	for chunck := range chunckChan {
+		calculator := chunck.Wait()
+		if state.Calculator == nil {
+			state.Calculator = cmd.newCalculator()
+		}
+		state.Calculator.Append(calculator)
+		values, weights := cmd.chunckValues(calculator, cmd.stats)
+		state.Chuncks = append(state.Chuncks, values)
+		state.Weights = append(state.Weights, weights)
+		if cmd.ldFile != "" {
+			ldValues, _ := cmd.chunckValues(calculator, calc.LDStatistics)
+			state.LDChuncks = append(state.LDChuncks, ldValues)
+		}
+		state.LastPos = chunck.lastPos
+		state.NextEnd = chunck.end + cmd.chunckSize
+
+		numChuncks++
+		if cmd.checkpointFile != "" && cmd.checkpointInterval > 0 && numChuncks%cmd.checkpointInterval == 0 {
+			checkpoint.save(cmd.checkpointFile)
+		}
+	}
+
+	state.Done = true
+	if cmd.checkpointFile != "" {
+		checkpoint.save(cmd.checkpointFile)
+	}
+}
+
+// runGenes calculates correlations in each gene,
+// and writes them in a long table.
+func (cmd *cmdCt) runGenes(genomeSNPChanChan chan genomeSNPChan, genomes *genomeSource, codonTable *taxonomy.GeneticCode) {
+	w := createFile(cmd.outFile)
+	defer w.Close()
+	if cmd.binned {
+		w.WriteString("gene_id\tlag_start\tlag_end\t")
+	} else {
+		w.WriteString("gene_id\tlag\t")
+	}
+	w.WriteString(strings.Join(cmd.stats, "\t") + "\tn")
+	if cmd.corr {
+		for _, name := range correlated(cmd.stats) {
+			w.WriteString(fmt.Sprintf("\t%s_corr\t%s_corr_se", name, name))
+		}
+	}
+	w.WriteString("\n")
+
+	for g := range genomeSNPChanChan {
+		profile := genomes.Profile(g.genome, codonTable)
+		if profile == nil {
+			log.Printf("Can not find the genome of %s, skip it.\n", g.genome)
+			for range g.snpChan {
+			}
+			continue
+		}
+
+		gffs := genomes.Gffs(g.genome)
+		selectSite := cmd.sites.Bind(g.genome, profile, gffs)
+		filteredSNPChan := cmd.filterSNP(g.snpChan, len(profile), selectSite)
+		grouper := newGeneGrouper(gffs)
+		for s := range filteredSNPChan {
+			for _, gene := range grouper.Add(s.Pos, s) {
+				cmd.writeGene(w, gene.id, cmd.calcGene(gene))
+			}
+		}
+		for _, gene := range grouper.Flush() {
+			cmd.writeGene(w, gene.id, cmd.calcGene(gene))
+		}
+	}
+}
 
-	// Split SNPs into different chuncks.
-	snpChanChan := cmd.splitChuncks(filteredSNPChan)
+// calcGene does calculation in SNPs of a gene.
+func (cmd *cmdCt) calcGene(gene *geneGroup) *calc.Calculator {
+	snpChan := make(chan *pileup.SNP)
+	go func() {
+		defer close(snpChan)
+		for _, item := range gene.items {
+			snpChan <- item.(*pileup.SNP)
+		}
+	}()
 
-	// For each chunck, do the calculation.
-	covsChan := cmd.doCalculation(snpChanChan)
+	// SNPs of a gene are in a chunck.
+	calculator := cmd.newCalculator()
+	for chunck := range cmd.pool.Submit(snpChan, math.MaxInt32, 0) {
+		calculator.Append(chunck.Wait())
+	}
+	return calculator
+}
 
-	// Collect results from each chunck.
-	csMeanVars, crMeanVars, ctMeanVars := cmd.collect(covsChan, cmd.maxl)
+// writeGene writes statistics and the number of SNP pairs of a gene,
+// at each lag (bin) having SNP pairs.
+func (cmd *cmdCt) writeGene(w *os.File, id string, calculator *calc.Calculator) {
+	for i := 0; i < calculator.Bins.Len(); i++ {
+		if n := calculator.Pairs[i]; n > 0 {
+			w.WriteString(id + "\t" + cmd.lagLabel(i) + "\t")
+			for _, name := range cmd.stats {
+				w.WriteString(fmt.Sprintf("%g\t", calculator.Stat(name).Result(i)))
+			}
+			w.WriteString(fmt.Sprintf("%d", n))
+			if cmd.corr {
+				for _, name := range correlated(cmd.stats) {
+					c := calculator.Stat(name).(calc.Correlator)
+					w.WriteString(fmt.Sprintf("\t%g\t%g", c.Correlation(i), c.CorrelationSE(i)))
+				}
+			}
+			w.WriteString("\n")
+		}
+	}
+}
 
-	// And finally, write results into the output file.
-	cmd.write(csMeanVars, crMeanVars, ctMeanVars, cmd.outFile)
+type genomeSNPChan struct {
+	genome  string
+	snpChan chan *pileup.SNP
 }
 
-// filterSNP returns SNPs in specific positions.
-func (cmd *cmdCt) filterSNP(snpChan <-chan *pileup.SNP, profile []profiling.Pos, posType byte) chan *pileup.SNP {
+// separate separates SNPs by references.
+// Each channel of SNPs should be consumed before receiving the next one.
+func (cmd *cmdCt) separate(snpChan <-chan *pileup.SNP) chan genomeSNPChan {
+	cc := make(chan genomeSNPChan)
+	go func() {
+		defer close(cc)
+		var c genomeSNPChan
+		for s := range snpChan {
+			if c.snpChan == nil || c.genome != s.Ref {
+				if c.snpChan != nil {
+					close(c.snpChan)
+				}
+				c = genomeSNPChan{genome: s.Ref, snpChan: make(chan *pileup.SNP)}
+				cc <- c
+			}
+			c.snpChan <- s
+		}
+		if c.snpChan != nil {
+			close(c.snpChan)
+		}
+	}()
+	return cc
+}
+
+// filterSNP returns SNPs in selected positions of a genome.
+func (cmd *cmdCt) filterSNP(snpChan <-chan *pileup.SNP, genomeLen int, selectSite func(pos int) bool) chan *pileup.SNP {
+	regionEnd := cmd.regionEnd
+	if regionEnd <= 0 || regionEnd > genomeLen {
+		regionEnd = genomeLen
+	}
+
 	c := make(chan *pileup.SNP)
 	go func() {
 		defer close(c)
 		for s := range snpChan {
-			if s.Pos >= cmd.regionStart && s.Pos < cmd.regionEnd {
-				p := profile[s.Pos].Type
-				if checkPosType(posType, p) {
+			if s.Pos >= cmd.regionStart && s.Pos < regionEnd {
+				if selectSite(s.Pos) {
+					s = cmd.errModel.filterAlleles(s, qualOffset(cmd.pileupFormat))
 					c <- filterOverlap(s)
 				}
 			}
@@ -120,101 +411,23 @@ func (cmd *cmdCt) panic(msg string) {
 	}
 }
 
-// calcInChunck does calculation in a chunck of SNPs.
-func (cmd *cmdCt) calcInChunck(snpChan chan *pileup.SNP) *calc.Calculator {
-	// Create job channel.
-	// Each job is a array of SNPs, which we will calculate
-	// correlations of the first SNP with the rest ones.
-	jobChan := make(chan []*pileup.SNP)
-	go func() {
-		defer close(jobChan)
-		arr := []*pileup.SNP{}
-		for s := range snpChan {
-			arr = append(arr, s)
-			lag := s.Pos - arr[0].Pos
-
-			if lag < 0 {
-				cmd.panic("SNPs are not in order.")
-			}
-
-			if lag >= cmd.maxl {
-				jobChan <- arr
-				arr = arr[1:]
+// newCalculator returns a calculator of statistics,
+// which also calculates LD if LD is wanted.
+func (cmd *cmdCt) newCalculator() *calc.Calculator {
+	names := append([]string{}, cmd.stats...)
+	if cmd.ldFile != "" {
+		for _, name := range calc.LDStatistics {
+			if !hasString(names, name) {
+				names = append(names, name)
 			}
 		}
-		jobChan <- arr
-	}()
-
-	// Make ncpu workers.
-	// For each worker, do the calculation,
-	// and push the result into a channel.
-	ncpu := runtime.GOMAXPROCS(0)
-	c := make(chan *calc.Calculator)
-	for i := 0; i < ncpu; i++ {
-		go func() {
-			covs := calc.New(cmd.maxl)
-			maxN := 10000
-			xArr := make([]float64, maxN)
-			yArr := make([]float64, maxN)
-			for arr := range jobChan {
-				cmd.calcSNPArr(arr, covs, xArr, yArr)
-			}
-			c <- covs
-		}()
 	}
-
-	// Wait for all the worker,
-	// and collect their results.
-	var covs *calc.Calculator
-	for i := 0; i < ncpu; i++ {
-		cc := <-c
-		if i == 0 {
-			covs = cc
-		} else {
-			covs.Append(cc)
-		}
+	c, err := calc.NewWithStatistics(cmd.bins, names...)
+	if err != nil {
+		log.Fatalln(err)
 	}
-
-	return covs
-}
-
-// splitChuncks split the genome of SNPs into several chuncks.
-// Each chunck is a channel of SNP,
-// and we returns a channel of channel.
-func (cmd *cmdCt) splitChuncks(snpChan chan *pileup.SNP) chan chan *pileup.SNP {
-	cc := make(chan chan *pileup.SNP)
-	go func() {
-		defer close(cc)
-		currentChunkEnd := cmd.chunckSize + cmd.regionStart
-		c := make(chan *pileup.SNP)
-		cc <- c
-		for s := range snpChan {
-			if s.Pos > currentChunkEnd {
-				close(c)
-				c = make(chan *pileup.SNP)
-				cc <- c
-				currentChunkEnd += cmd.chunckSize
-			}
-			c <- s
-		}
-		close(c)
-	}()
-
-	return cc
-}
-
-func (cmd *cmdCt) doCalculation(snpChanChan chan chan *pileup.SNP) chan *calc.Calculator {
-	cc := make(chan *calc.Calculator)
-
-	go func() {
-		defer close(cc)
-		for snpChan := range snpChanChan {
-			covs := cmd.calcInChunck(snpChan)
-			cc <- covs
-		}
-	}()
-
-	return cc
+	c.PairWeighted = cmd.weighting == pairsWeighting
+	return c
 }
 
 // calcSNPArr calculate correlation of the first SNP with the rest.
@@ -223,7 +436,7 @@ func (cmd *cmdCt) doCalculation(snpChanChan chan chan *pileup.SNP) chan *calc.Ca
 // and compute several correlations, which is contained in a calculator.
 // A calculator here is a black box.
 // calcSNPArr only push inputs into the calculator.
-func (cmd *cmdCt) calcSNPArr(snpArr []*pileup.SNP, calculator *calc.Calculator, xArr, yArr []float64) {
+func (cmd *cmdCt) calcSNPArr(snpArr []*pileup.SNP, calculator *calc.Calculator, sampler *pairSampler) {
 	s1 := snpArr[0]
 	m := make(map[string]pileup.Allele)
 	for _, a := range s1.Alleles {
@@ -253,19 +466,77 @@ func (cmd *cmdCt) calcSNPArr(snpArr []*pileup.SNP, calculator *calc.Calculator,
 			continue
 		}
 
+		var table calc.HaplotypeTable
+		for _, p := range pairs[:numPair] {
+			table.Add(p.A.Base, p.B.Base)
+		}
+
+		if cmd.method == pairsMethod {
+			xArr, yArr := sampler.Compare(pairs[:numPair], cmd.diffBases)
+			calculator.IncrementCompared(xArr, yArr, &table, l)
+		} else {
+			calculator.IncrementTable(&table, l)
+		}
+	}
+}
+
+// pairSampler compares every two read pairs of a SNP pair,
+// or, if there are more than maxPairs of them,
+// a uniform random sample of maxPairs,
+// so that no read is favored by its order in the pileup.
+type pairSampler struct {
+	maxPairs   int
+	rng        *rand.Rand
+	xArr, yArr []float64
+}
+
+func newPairSampler(maxPairs int, seed int64) *pairSampler {
+	s := pairSampler{maxPairs: maxPairs}
+	s.rng = rand.New(rand.NewSource(seed))
+	return &s
+}
+
+// Compare returns differences of the first and the second bases
+// between compared read pairs.
+// The returned arrays are reused by the next call.
+func (s *pairSampler) Compare(pairs []AllelePair, diff func(a, b byte) float64) (xArr, yArr []float64) {
+	n := len(pairs)
+	total := n * (n - 1) / 2
+	if s.maxPairs <= 0 || total <= s.maxPairs {
+		s.reserve(total)
 		k := 0
-		for i := 0; i < numPair && k < len(xArr); i++ {
+		for i := 0; i < n; i++ {
 			p1 := pairs[i]
-			for j := i + 1; j < numPair && k < len(xArr); j++ {
+			for j := i + 1; j < n; j++ {
 				p2 := pairs[j]
-				x := cmd.diffBases(p1.A.Base, p2.A.Base)
-				y := cmd.diffBases(p1.B.Base, p2.B.Base)
-				xArr[k] = x
-				yArr[k] = y
+				s.xArr[k] = diff(p1.A.Base, p2.A.Base)
+				s.yArr[k] = diff(p1.B.Base, p2.B.Base)
 				k++
 			}
 		}
-		calculator.Increment(xArr[:k], yArr[:k], l)
+		return s.xArr[:k], s.yArr[:k]
+	}
+
+	// sample pairs of distinct read pairs with replacement,
+	// each comparison being equally likely.
+	s.reserve(s.maxPairs)
+	for k := 0; k < s.maxPairs; k++ {
+		i := s.rng.Intn(n)
+		j := s.rng.Intn(n - 1)
+		if j >= i {
+			j++
+		}
+		p1, p2 := pairs[i], pairs[j]
+		s.xArr[k] = diff(p1.A.Base, p2.A.Base)
+		s.yArr[k] = diff(p1.B.Base, p2.B.Base)
+	}
+	return s.xArr[:s.maxPairs], s.yArr[:s.maxPairs]
+}
+
+func (s *pairSampler) reserve(n int) {
+	if len(s.xArr) < n {
+		s.xArr = make([]float64, n)
+		s.yArr = make([]float64, n)
 	}
 }
 
@@ -298,47 +569,159 @@ func (cmd *cmdCt) findPairs(m map[string]pileup.Allele, mates []pileup.Allele, p
 	return
 }
 
-// collect
-func (cmd *cmdCt) collect(calculatorChan chan *calc.Calculator, maxl int) (csMeanVars, crMeanVars, ctMeanVars *calc.MeanVariances) {
-
-	csMeanVars = calc.NewMeanVariances(cmd.maxl)
-	ctMeanVars = calc.NewMeanVariances(cmd.maxl)
-	crMeanVars = calc.NewMeanVariances(cmd.maxl)
-
-	meanvars := []*calc.MeanVariances{csMeanVars, crMeanVars, ctMeanVars}
-	for calculator := range calculatorChan {
-		for i := 0; i < calculator.MaxL; i++ {
-			cs := calculator.Cs.GetMean(i)
-			cr := calculator.Cr.GetResult(i)
-			ct := calculator.Ct.GetResult(i)
-			n := calculator.Cs.GetN(i)
-			if n > 10 {
-				vs := []float64{cs, cr, ct}
-				for j := range vs {
-					if !math.IsNaN(vs[j]) {
-						meanvars[j].Increment(i, vs[j])
-					}
-				}
+// ctCollector collects statistics of chuncks.
+type ctCollector struct {
+	names      []string
+	meanvars   []*calc.MeanVariances
+	calculator *calc.Calculator // chuncks appended, if any, for correlations.
+	chuncks    [][][]float64    // chunck values kept for bootstrapping.
+	weights    [][][]float64    // chunck weights, nil for equal weights.
+	keep       bool
+}
+
+// newCollector returns a collector of statistics of names.
+func (cmd *cmdCt) newCollector(names []string) *ctCollector {
+	c := ctCollector{names: names, keep: cmd.bootstrapper != nil}
+	for range names {
+		c.meanvars = append(c.meanvars, calc.NewMeanVariances(cmd.bins.Len()))
+	}
+	return &c
+}
+
+// Add adds values of a chunck,
+// weighted by weights, or equally if weights are nil.
+func (c *ctCollector) Add(values, weights [][]float64) {
+	for j := range values {
+		for i, v := range values[j] {
+			w := 1.0
+			if weights != nil {
+				w = weights[j][i]
+			}
+			if !math.IsNaN(v) {
+				c.meanvars[j].IncrementWeighted(i, v, w)
 			}
+		}
+	}
 
+	if c.keep {
+		c.chuncks = append(c.chuncks, values)
+		if weights != nil {
+			c.weights = append(c.weights, weights)
+		}
+	}
+}
+
+// chunckValues returns statistics of names of a chunck at each lag,
+// which are NaN if the chunck has too few SNP pairs at the lag,
+// and their weights.
+func (cmd *cmdCt) chunckValues(calculator *calc.Calculator, names []string) (values, weights [][]float64) {
+	values = make([][]float64, len(names))
+	weights = make([][]float64, len(names))
+	for j, name := range names {
+		values[j] = make([]float64, calculator.Bins.Len())
+		weights[j] = make([]float64, calculator.Bins.Len())
+		stat := calculator.Stat(name)
+		for i := range values[j] {
+			values[j][i] = math.NaN()
+			if calculator.Pairs[i] > 10 {
+				values[j][i] = stat.Result(i)
+				weights[j][i] = weightOf(cmd.weighting, stat.N(i), stat.Var(i))
+			}
 		}
 	}
 
 	return
 }
 
-// write
-func (cmd *cmdCt) write(csMeanVars, crMeanVars, ctMeanVars *calc.MeanVariances, filename string) {
+// confidenceIntervals resamples chuncks,
+// and returns lower and upper bounds of each statistic.
+func (cmd *cmdCt) confidenceIntervals(collector *ctCollector) (lo, hi [][]float64) {
+	chuncks := collector.chuncks
+	for j := range collector.meanvars {
+		units := make([][]float64, len(chuncks))
+		var weights [][]float64
+		for i := range chuncks {
+			units[i] = chuncks[i][j]
+			if collector.weights != nil {
+				weights = append(weights, collector.weights[i][j])
+			}
+		}
+		l, h := cmd.bootstrapper.MeanCI(units, weights, cmd.bins.Len())
+		lo = append(lo, l)
+		hi = append(hi, h)
+	}
+	return
+}
+
+// correlated returns names of statistics having correlations.
+func correlated(names []string) []string {
+	var corrNames []string
+	for _, name := range names {
+		s, err := calc.NewStatistic(name, 0)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.(calc.Correlator); ok {
+			corrNames = append(corrNames, name)
+		}
+	}
+	return corrNames
+}
+
+// setBins sets bins of lags,
+// which also sets the max lag.
+func (cmd *cmdCt) setBins(bins *calc.Bins) {
+	cmd.bins = bins
+	cmd.binned = !bins.IsLinear()
+	cmd.maxl = bins.MaxL()
+}
+
+// lagLabel returns the lag of bin i,
+// or its range (start and end) if lags are binned.
+func (cmd *cmdCt) lagLabel(i int) string {
+	if cmd.binned {
+		start, end := cmd.bins.Range(i)
+		return fmt.Sprintf("%d\t%d", start, end)
+	}
+	return strconv.Itoa(i)
+}
+
+// write writes the lag (or the range of lags) and
+// mean, variance and number of chuncks of each statistic,
+// followed by confidence intervals if bootstrapping is enabled.
+// Means and variances are weighted, and variances are unbiased
+// for reliability weights.
+// If correlations are wanted, the correlation and its standard error
+// of appended chuncks follow for each covariance statistic.
+func (cmd *cmdCt) write(collector *ctCollector, filename string) {
+	var lo, hi [][]float64
+	if cmd.bootstrapper != nil {
+		lo, hi = cmd.confidenceIntervals(collector)
+	}
+
 	w, err := os.Create(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer w.Close()
-	mvs := []*calc.MeanVariances{csMeanVars, crMeanVars, ctMeanVars}
+	mvs := collector.meanvars
 	for i := 0; i < mvs[0].Size(); i++ {
-		w.WriteString(fmt.Sprintf("%d\t", i))
-		for _, mv := range mvs {
+		w.WriteString(cmd.lagLabel(i) + "\t")
+		for j, mv := range mvs {
 			w.WriteString(fmt.Sprintf("%g\t%g\t%d\t", mv.GetMean(i), mv.GetVar(i), mv.GetN(i)))
+			if lo != nil {
+				w.WriteString(fmt.Sprintf("%g\t%g\t", lo[j][i], hi[j][i]))
+			}
+		}
+		if cmd.corr {
+			for _, name := range correlated(collector.names) {
+				r, se := math.NaN(), math.NaN()
+				if collector.calculator != nil {
+					c := collector.calculator.Stat(name).(calc.Correlator)
+					r, se = c.Correlation(i), c.CorrelationSE(i)
+				}
+				w.WriteString(fmt.Sprintf("%g\t%g\t", r, se))
+			}
 		}
 		w.WriteString("\n")
 	}
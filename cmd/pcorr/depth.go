@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/biogo/feat/gff"
+)
+
+type cmdDepth struct {
+	debug                             bool
+	inFile, format                    string
+	outFile, summaryFile, featureFile string
+	fastaFile, gffFile                string
+	minBQ, minMQ, binSize             int
+	minDepths                         []int
+}
+
+// refDepth contains the depth at every position of a reference.
+type refDepth struct {
+	ref   string
+	depth []int
+}
+
+// Run is the main function.
+func (cmd *cmdDepth) Run() {
+	if cmd.binSize <= 0 {
+		log.Fatalln("bin size should be positive.")
+	}
+
+	var depthChan chan refDepth
+	if cmd.isBam() {
+		depthChan = cmd.depthBam()
+	} else {
+		depthChan = cmd.depthPileup()
+	}
+
+	var gffs map[string][]*gff.Record
+	var fw *bufio.Writer
+	if cmd.gffFile != "" {
+		if cmd.featureFile == "" {
+			log.Fatalln("feature output file is required when a GFF file is given.")
+		}
+		gffs = make(map[string][]*gff.Record)
+		for _, r := range readGff(cmd.gffFile) {
+			gffs[r.SeqName] = append(gffs[r.SeqName], r)
+		}
+		f := createFile(cmd.featureFile)
+		defer f.Close()
+		fw = bufio.NewWriter(f)
+		defer fw.Flush()
+		fw.WriteString("id\tref\tstart\tend\tmean\tmedian" + cmd.breadthHeader() + "\n")
+	}
+
+	var w, sw *os.File
+	if cmd.outFile != "" {
+		w = createFile(cmd.outFile)
+		defer w.Close()
+	} else {
+		w = os.Stdout
+	}
+	if cmd.summaryFile != "" {
+		sw = createFile(cmd.summaryFile)
+		defer sw.Close()
+	} else {
+		sw = os.Stderr
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	sw.WriteString("ref\tlength\tmean\tmedian" + cmd.breadthHeader() + "\n")
+	for rd := range depthChan {
+		cmd.writeBedGraph(bw, rd)
+
+		mean, median, breadth := depthSummary(rd.depth, cmd.minDepths)
+		sw.WriteString(fmt.Sprintf("%s\t%d\t%g\t%g%s\n", rd.ref, len(rd.depth), mean, median, formatBreadth(breadth)))
+
+		for _, r := range gffs[rd.ref] {
+			start, end := r.Start-1, r.End
+			if start < 0 || end > len(rd.depth) || start >= end {
+				if cmd.debug {
+					log.Printf("feature %s is out of the range of %s\n", gffID(r), rd.ref)
+				}
+				continue
+			}
+			mean, median, breadth := depthSummary(rd.depth[start:end], cmd.minDepths)
+			fw.WriteString(fmt.Sprintf("%s\t%s\t%d\t%d\t%g\t%g%s\n", gffID(r), rd.ref, start, end, mean, median, formatBreadth(breadth)))
+		}
+	}
+}
+
+func (cmd *cmdDepth) isBam() bool {
+	return cmd.format == "bam" || strings.HasSuffix(cmd.inFile, ".bam") || strings.HasSuffix(cmd.inFile, ".sam")
+}
+
+func (cmd *cmdDepth) breadthHeader() string {
+	s := ""
+	for _, d := range cmd.minDepths {
+		s += fmt.Sprintf("\tbreadth_%d", d)
+	}
+	return s
+}
+
+func formatBreadth(breadth []float64) string {
+	s := ""
+	for _, b := range breadth {
+		s += fmt.Sprintf("\t%g", b)
+	}
+	return s
+}
+
+// depthBam calculates depths from a bam (or sam) file sorted by coordinate.
+// References without any reads are reported with zero depth.
+func (cmd *cmdDepth) depthBam() chan refDepth {
+	c := make(chan refDepth)
+	go func() {
+		defer close(c)
+		header := readBamHeader(cmd.inFile)
+		_, readChan := readBamFile(cmd.inFile)
+
+		done := make(map[string]bool)
+		var current *refDepth
+		for r := range readChan {
+			if !cmd.useRead(r) {
+				continue
+			}
+
+			if current == nil || current.ref != r.Ref.Name() {
+				if current != nil {
+					c <- *current
+				}
+				if done[r.Ref.Name()] {
+					log.Fatalf("%s is not sorted by coordinate.\n", cmd.inFile)
+				}
+				done[r.Ref.Name()] = true
+				current = &refDepth{ref: r.Ref.Name(), depth: make([]int, r.Ref.Len())}
+			}
+			cmd.addRead(current.depth, r)
+		}
+		if current != nil {
+			c <- *current
+		}
+
+		for _, ref := range header.Refs() {
+			if !done[ref.Name()] {
+				c <- refDepth{ref: ref.Name(), depth: make([]int, ref.Len())}
+			}
+		}
+	}()
+	return c
+}
+
+// useRead checks whether a read counts for the depth,
+// using the same mapping quality rule as pileup.
+func (cmd *cmdDepth) useRead(r sam.Record) bool {
+	if r.Ref == nil || r.Pos < 0 {
+		return false
+	}
+	if r.Flags&(sam.Unmapped|sam.Secondary|sam.Supplementary|sam.Duplicate) != 0 {
+		return false
+	}
+	mapQ := int(r.MapQ)
+	return mapQ > cmd.minMQ && mapQ != 255
+}
+
+// addRead increments depth at the reference positions aligned by the read.
+func (cmd *cmdDepth) addRead(depth []int, r sam.Record) {
+	pos, p := r.Pos, 0 // positions in the reference and the read.
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual:
+			for i := 0; i < co.Len(); i++ {
+				if pos+i < len(depth) && (len(r.Qual) <= p+i || int(r.Qual[p+i]) > cmd.minBQ) {
+					depth[pos+i]++
+				}
+			}
+			pos += co.Len()
+			p += co.Len()
+		case sam.CigarInsertion, sam.CigarSoftClipped:
+			p += co.Len()
+		case sam.CigarDeletion, sam.CigarSkipped:
+			pos += co.Len()
+		}
+	}
+}
+
+// depthPileup calculates depths from a pileup file.
+// Reference lengths are taken from the fasta file when it is given,
+// otherwise a reference ends at its last covered position.
+func (cmd *cmdDepth) depthPileup() chan refDepth {
+	c := make(chan refDepth)
+	go func() {
+		defer close(c)
+		var genomes map[string][]byte
+		if cmd.fastaFile != "" {
+			genomes = readGenomes(cmd.fastaFile)
+		}
+
+		f := openFile(cmd.inFile)
+		defer f.Close()
+
+		done := make(map[string]bool)
+		var current *refDepth
+		for s := range readPileup(f, 0, 0, cmd.format) {
+			if current == nil || current.ref != s.Ref {
+				if current != nil {
+					c <- *current
+				}
+				if done[s.Ref] {
+					log.Fatalf("%s is not sorted by coordinate.\n", cmd.inFile)
+				}
+				done[s.Ref] = true
+				current = &refDepth{ref: s.Ref, depth: make([]int, len(genomes[s.Ref]))}
+			}
+
+			for s.Pos >= len(current.depth) {
+				current.depth = append(current.depth, 0)
+			}
+			for _, a := range s.Alleles {
+				if int(a.Qual)-qualOffset(cmd.format) > cmd.minBQ {
+					current.depth[s.Pos]++
+				}
+			}
+		}
+		if current != nil {
+			c <- *current
+		}
+	}()
+	return c
+}
+
+// writeBedGraph writes depths as bedGraph records.
+// Adjacent positions of the same depth are merged when bin size is 1,
+// otherwise the mean depth of every bin is written.
+// Zero-depth intervals are omitted.
+func (cmd *cmdDepth) writeBedGraph(w io.Writer, rd refDepth) {
+	if cmd.binSize == 1 {
+		start := 0
+		for i := 1; i <= len(rd.depth); i++ {
+			if i == len(rd.depth) || rd.depth[i] != rd.depth[start] {
+				if rd.depth[start] > 0 {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", rd.ref, start, i, rd.depth[start])
+				}
+				start = i
+			}
+		}
+		return
+	}
+
+	for start := 0; start < len(rd.depth); start += cmd.binSize {
+		end := start + cmd.binSize
+		if end > len(rd.depth) {
+			end = len(rd.depth)
+		}
+		total := 0
+		for _, d := range rd.depth[start:end] {
+			total += d
+		}
+		if total > 0 {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%g\n", rd.ref, start, end, float64(total)/float64(end-start))
+		}
+	}
+}
+
+// depthSummary returns the mean and median depth,
+// and the fraction of positions covered by at least minDepths reads.
+func depthSummary(depth []int, minDepths []int) (mean, median float64, breadth []float64) {
+	breadth = make([]float64, len(minDepths))
+	if len(depth) == 0 {
+		return
+	}
+
+	// count positions by depth, which avoids sorting the whole array.
+	counts := make(map[int]int)
+	maxDepth, total := 0, 0
+	for _, d := range depth {
+		counts[d]++
+		total += d
+		if d > maxDepth {
+			maxDepth = d
+		}
+		for i, m := range minDepths {
+			if d >= m {
+				breadth[i]++
+			}
+		}
+	}
+
+	n := len(depth)
+	mean = float64(total) / float64(n)
+	for i := range breadth {
+		breadth[i] /= float64(n)
+	}
+
+	// the median is the average of the (n-1)/2-th and n/2-th smallest depths.
+	lower, upper := -1, -1
+	seen := 0
+	for d := 0; d <= maxDepth && upper < 0; d++ {
+		seen += counts[d]
+		if lower < 0 && seen > (n-1)/2 {
+			lower = d
+		}
+		if seen > n/2 {
+			upper = d
+		}
+	}
+	median = float64(lower+upper) / 2
+
+	return
+}
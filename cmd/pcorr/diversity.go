@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/mingzhi/biogo/feat/gff"
+)
+
+// siteDiversity contains the diversity of a callable site.
+type siteDiversity struct {
+	Pos int
+	N   int // number of bases.
+	Pi  float64
+}
+
+// diversityStat summarizes diversity over a group of sites.
+type diversityStat struct {
+	Sites       int // number of callable sites.
+	Segregating int
+	Pi          float64
+	ThetaW      float64
+	TajimaD     float64
+}
+
+// summarizeDiversity calculates per-site pi and Watterson's theta,
+// and Tajima's D of a group of sites.
+// As sample sizes (coverages) vary among sites,
+// Watterson's estimator sums 1/a1 over segregating sites,
+// and the variance of D uses the mean sample size of segregating sites.
+func summarizeDiversity(sites []siteDiversity) (d diversityStat) {
+	d.Sites = len(sites)
+	var sumPi, sumTheta float64
+	var sumN int
+	for _, s := range sites {
+		sumPi += s.Pi
+		if s.Pi > 0 {
+			d.Segregating++
+			a1, _ := harmonic(s.N)
+			sumTheta += 1.0 / a1
+			sumN += s.N
+		}
+	}
+
+	if d.Sites == 0 {
+		d.Pi, d.ThetaW, d.TajimaD = math.NaN(), math.NaN(), math.NaN()
+		return
+	}
+
+	d.Pi = sumPi / float64(d.Sites)
+	d.ThetaW = sumTheta / float64(d.Sites)
+	d.TajimaD = math.NaN()
+	if d.Segregating > 0 {
+		n := int(math.Floor(float64(sumN)/float64(d.Segregating) + 0.5))
+		d.TajimaD = tajimaD(sumPi, sumTheta, d.Segregating, n)
+	}
+
+	return
+}
+
+// harmonic returns a1 = sum 1/i and a2 = sum 1/i^2, for i from 1 to n-1.
+func harmonic(n int) (a1, a2 float64) {
+	for i := 1; i < n; i++ {
+		a1 += 1.0 / float64(i)
+		a2 += 1.0 / float64(i*i)
+	}
+	return
+}
+
+// tajimaD calculates Tajima's D from the total pairwise difference,
+// Watterson's estimate, and the number of segregating sites,
+// with sample size n.
+func tajimaD(pi, thetaW float64, s, n int) float64 {
+	if n < 4 || s == 0 {
+		return math.NaN()
+	}
+	a1, a2 := harmonic(n)
+	nf := float64(n)
+	b1 := (nf + 1) / (3 * (nf - 1))
+	b2 := 2 * (nf*nf + nf + 3) / (9 * nf * (nf - 1))
+	c1 := b1 - 1/a1
+	c2 := b2 - (nf+2)/(a1*nf) + a2/(a1*a1)
+	e1 := c1 / a1
+	e2 := c2 / (a1*a1 + a2)
+	sf := float64(s)
+	return (pi - thetaW) / math.Sqrt(e1*sf+e2*sf*(sf-1))
+}
+
+// diversityScanner collects sites of a reference,
+// and writes diversity statistics over windows and GFF records.
+type diversityScanner struct {
+	window, step int
+	gffs         func(ref string) []*gff.Record // GFF records of a reference, or nil.
+	errModel     errorModel
+	bootstrapper *bootstrapper
+	blockSize    int // length of blocks of sites resampled by bootstrapper.
+	w            *bufio.Writer
+
+	ref   string
+	sites []siteDiversity
+}
+
+func newDiversityScanner(w io.Writer, window, step int, gffs func(ref string) []*gff.Record, errModel errorModel, b *bootstrapper, blockSize int) *diversityScanner {
+	d := diversityScanner{window: window, step: step, gffs: gffs, errModel: errModel, bootstrapper: b, blockSize: blockSize}
+	if d.step <= 0 {
+		d.step = d.window
+	}
+	d.w = bufio.NewWriter(w)
+	d.w.WriteString("ref\tstart\tend\tname\tsites\tsegregating\tpi\ttheta_w\ttajima_d")
+	if d.bootstrapper != nil {
+		d.w.WriteString("\tpi_lo\tpi_hi\ttheta_w_lo\ttheta_w_hi")
+	}
+	d.w.WriteString("\n")
+	return &d
+}
+
+// Add adds a site.
+func (d *diversityScanner) Add(pi Pi) {
+	if pi.Ref != d.ref {
+		d.flush()
+		d.ref = pi.Ref
+	}
+
+	nums := pi.Counts(d.errModel)
+	total := 0
+	for _, n := range nums {
+		total += n
+	}
+	if total < 2 {
+		return
+	}
+	d.sites = append(d.sites, siteDiversity{Pos: pi.Pos, N: total, Pi: piFromCounts(nums)})
+}
+
+// Close writes the last reference and flushes the writer.
+func (d *diversityScanner) Close() {
+	d.flush()
+	d.w.Flush()
+}
+
+// flush writes statistics of the current reference.
+func (d *diversityScanner) flush() {
+	if len(d.sites) == 0 {
+		return
+	}
+
+	if d.window > 0 {
+		last := d.sites[len(d.sites)-1].Pos
+		for start := 0; start <= last; start += d.step {
+			d.write(start, start+d.window, ".")
+		}
+	}
+
+	if d.gffs != nil {
+		for _, r := range d.gffs(d.ref) {
+			d.write(r.Start-1, r.End, gffID(r))
+		}
+	}
+
+	d.sites = d.sites[:0]
+}
+
+// write writes statistics of sites in [start, end).
+func (d *diversityScanner) write(start, end int, name string) {
+	i := sort.Search(len(d.sites), func(i int) bool { return d.sites[i].Pos >= start })
+	j := sort.Search(len(d.sites), func(i int) bool { return d.sites[i].Pos >= end })
+	if i >= j {
+		return
+	}
+	s := summarizeDiversity(d.sites[i:j])
+	d.w.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%d\t%g\t%g\t%g",
+		d.ref, start, end, name, s.Sites, s.Segregating, s.Pi, s.ThetaW, s.TajimaD))
+	if d.bootstrapper != nil {
+		piLo, piHi, thetaLo, thetaHi := d.confidenceIntervals(d.sites[i:j], start)
+		d.w.WriteString(fmt.Sprintf("\t%g\t%g\t%g\t%g", piLo, piHi, thetaLo, thetaHi))
+	}
+	d.w.WriteString("\n")
+}
+
+// confidenceIntervals resamples blocks of contiguous sites,
+// which are blockSize long from start,
+// so that linkage between nearby sites is kept,
+// and returns confidence intervals of pi and Watterson's theta.
+func (d *diversityScanner) confidenceIntervals(sites []siteDiversity, start int) (piLo, piHi, thetaLo, thetaHi float64) {
+	var blocks [][]siteDiversity
+	for i := 0; i < len(sites); {
+		end := start + ((sites[i].Pos-start)/d.blockSize+1)*d.blockSize
+		j := i + sort.Search(len(sites)-i, func(k int) bool { return sites[i+k].Pos >= end })
+		blocks = append(blocks, sites[i:j])
+		i = j
+	}
+
+	pis, thetas := []float64{}, []float64{}
+	var resampled []siteDiversity
+	d.bootstrapper.Resample(len(blocks), func(indices []int) {
+		resampled = resampled[:0]
+		for _, i := range indices {
+			resampled = append(resampled, blocks[i]...)
+		}
+		s := summarizeDiversity(resampled)
+		pis = append(pis, s.Pi)
+		thetas = append(thetas, s.ThetaW)
+	})
+	piLo, piHi = d.bootstrapper.percentiles(pis)
+	thetaLo, thetaHi = d.bootstrapper.percentiles(thetas)
+	return
+}
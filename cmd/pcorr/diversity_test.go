@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+// TestTajimaD checks the example of n = 10 sequences,
+// of 16 segregating sites and 3.888 mean pairwise differences,
+// where e1 = 0.01906 and e2 = 0.004949 (Tajima 1989).
+func TestTajimaD(t *testing.T) {
+	a1, _ := harmonic(10)
+	d := tajimaD(3.888, 16/a1, 16, 10)
+	if math.Abs(d-(-1.447)) > 0.001 {
+		t.Errorf("expect D = -1.447, got %g\n", d)
+	}
+
+	if !math.IsNaN(tajimaD(1, 1, 1, 3)) || !math.IsNaN(tajimaD(0, 0, 0, 10)) {
+		t.Error("expect NaN of too few sequences or no segregating sites")
+	}
+}
+
+// TestBlockBootstrap resamples sites in a single block,
+// whose confidence intervals collapse to the estimates.
+func TestBlockBootstrap(t *testing.T) {
+	var sites []siteDiversity
+	for pos := 0; pos < 100; pos++ {
+		pi := 0.0
+		if pos%10 == 0 {
+			pi = 0.5
+		}
+		sites = append(sites, siteDiversity{Pos: pos, N: 10, Pi: pi})
+	}
+	s := summarizeDiversity(sites)
+
+	d := newDiversityScanner(ioutil.Discard, 100, 100, nil, errorModel{estimator: naiveEstimator}, newBootstrapper(50, 1), 1000)
+	piLo, piHi, thetaLo, thetaHi := d.confidenceIntervals(sites, 0)
+	if piLo != s.Pi || piHi != s.Pi || thetaLo != s.ThetaW || thetaHi != s.ThetaW {
+		t.Errorf("expect intervals of a single block at the estimates, got [%g, %g] and [%g, %g]\n", piLo, piHi, thetaLo, thetaHi)
+	}
+
+	d.blockSize = 10
+	piLo, piHi, _, _ = d.confidenceIntervals(sites, 0)
+	if piLo > s.Pi || piHi < s.Pi {
+		t.Errorf("expect pi %g in [%g, %g]\n", s.Pi, piLo, piHi)
+	}
+}
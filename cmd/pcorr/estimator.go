@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"math"
+
+	"github.com/mingzhi/pileup"
+)
+
+const (
+	naiveEstimator    = "naive"
+	binomialEstimator = "binomial"
+)
+
+// errorModel decides which alleles are explained by sequencing errors.
+// The naive estimator treats every base as a true allele.
+// The binomial estimator tests the count k of a minor allele
+// against Binomial(n, e/3), where n is the depth
+// and e is the mean per-base error probability from Phred qualities,
+// and discards the allele if P(X >= k) >= alpha.
+type errorModel struct {
+	estimator string
+	alpha     float64
+}
+
+func newErrorModel(estimator string, alpha float64) errorModel {
+	if estimator != naiveEstimator && estimator != binomialEstimator {
+		log.Fatalf("Can not recognize the estimator: %s\n", estimator)
+	}
+	return errorModel{estimator: estimator, alpha: alpha}
+}
+
+// isError checks whether k bases of an allele at a site of depth n
+// can be explained by sequencing errors of rate e.
+func (m errorModel) isError(k, n int, e float64) bool {
+	if m.estimator == naiveEstimator {
+		return false
+	}
+	return binomialTail(k, n, e/3) >= m.alpha
+}
+
+// errorAlleles returns alleles explained by sequencing errors
+// according to allele counts.
+// The most frequent allele is never discarded,
+// which is the lowest base of ties.
+func (m errorModel) errorAlleles(counts map[byte]int, e float64) map[byte]bool {
+	errors := make(map[byte]bool)
+	if m.estimator == naiveEstimator {
+		return errors
+	}
+
+	major, n := -1, 0
+	for b, k := range counts {
+		n += k
+		if major < 0 || k > counts[byte(major)] || (k == counts[byte(major)] && int(b) < major) {
+			major = int(b)
+		}
+	}
+	for b, k := range counts {
+		if int(b) != major && m.isError(k, n, e) {
+			errors[b] = true
+		}
+	}
+	return errors
+}
+
+// filterAlleles removes alleles explained by sequencing errors from a SNP.
+// Qualities are Phred scores plus the offset.
+func (m errorModel) filterAlleles(s *pileup.SNP, offset int) *pileup.SNP {
+	if m.estimator == naiveEstimator {
+		return s
+	}
+
+	counts := make(map[byte]int)
+	quals := []byte{}
+	for _, a := range s.Alleles {
+		if isATGC(a.Base) {
+			counts[a.Base]++
+			quals = append(quals, a.Qual)
+		}
+	}
+	errors := m.errorAlleles(counts, meanError(quals, offset))
+	if len(errors) == 0 {
+		return s
+	}
+
+	alleles := []pileup.Allele{}
+	for _, a := range s.Alleles {
+		if !errors[a.Base] {
+			alleles = append(alleles, a)
+		}
+	}
+	s.Alleles = alleles
+	return s
+}
+
+// meanError returns the mean error probability of Phred qualities.
+func meanError(quals []byte, offset int) float64 {
+	if len(quals) == 0 {
+		return 0
+	}
+	var e float64
+	for _, q := range quals {
+		e += math.Pow(10, -float64(int(q)-offset)/10)
+	}
+	return e / float64(len(quals))
+}
+
+// qualOffset returns the offset of base qualities in a pileup format.
+// Text pileup encodes qualities as Phred+33 characters,
+// while json pileup from the pileup command stores Phred scores.
+func qualOffset(format string) int {
+	if format == "tab" {
+		return 33
+	}
+	return 0
+}
+
+// binomialTail returns P(X >= k) for X ~ Binomial(n, p).
+func binomialTail(k, n int, p float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if k > n || p <= 0 {
+		return 0
+	}
+	if p >= 1 {
+		return 1
+	}
+
+	lgn, _ := math.Lgamma(float64(n + 1))
+	lp, lq := math.Log(p), math.Log1p(-p)
+	var tail float64
+	for i := k; i <= n; i++ {
+		lgi, _ := math.Lgamma(float64(i + 1))
+		lgni, _ := math.Lgamma(float64(n - i + 1))
+		t := math.Exp(lgn - lgi - lgni + float64(i)*lp + float64(n-i)*lq)
+		tail += t
+		// terms decrease beyond the mode.
+		if float64(i) > float64(n)*p && t < tail*1e-12 {
+			break
+		}
+	}
+	return math.Min(tail, 1)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestErrorAllelesTies keeps the lowest base of tied major alleles,
+// whatever the order of iterating counts.
+func TestErrorAllelesTies(t *testing.T) {
+	m := newErrorModel(binomialEstimator, 0.001)
+	expected := map[byte]bool{'C': true, 'G': true, 'T': true}
+	for k := 0; k < 50; k++ {
+		counts := map[byte]int{'T': 1, 'G': 1, 'C': 1, 'A': 1}
+		if errors := m.errorAlleles(counts, 0.3); !reflect.DeepEqual(errors, expected) {
+			t.Fatalf("expect error alleles %v, got %v\n", expected, errors)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type cmdFit struct {
+	inFile, outFile string
+	format, stat    string
+	stats           []string // statistics of ct output.
+	corr            bool     // whether ct output has correlations.
+	minl, maxl      int
+	binned          bool // whether lags of ct output are binned.
+	bootstrapper    *bootstrapper
+}
+
+// profilePoint is the correlation at a lag,
+// or in a bin of lags [L, End),
+// with the standard error of its mean.
+type profilePoint struct {
+	L, End float64
+	Y, SE  float64
+}
+
+// expected returns the expected correlation of the point,
+// which is averaged over at most 16 lags evenly spaced in a bin.
+func (p profilePoint) expected(params []float64) float64 {
+	if p.End <= p.L+1 {
+		return correlationModel(p.L, params)
+	}
+	n := math.Min(p.End-p.L, 16)
+	step := (p.End - p.L) / n
+	var sum float64
+	for i := 0.0; i < n; i++ {
+		sum += correlationModel(math.Floor(p.L+i*step), params)
+	}
+	return sum / n
+}
+
+// fitResult contains fitted parameters and the goodness of fit.
+type fitResult struct {
+	Params  []float64 // theta, phi and f.
+	Chi2    float64
+	Dof     int
+	R2      float64
+	Success bool
+}
+
+var fitParamNames = []string{"theta", "phi", "f"}
+
+// correlationModel returns the expected correlation at lag l.
+//
+// For a pair of sequences, the covariance of differences at two sites
+// is theta^2 (rho + 18) / (rho^2 + 13 rho + 18) (Hudson 1983),
+// where rho is the scaled recombination rate between the sites.
+// With recombination by fragments of mean length f (gene conversion),
+// a fragment separates two sites l apart
+// with rate rho(l) = 2 phi theta f (1 - exp(-l/f)) (Wiuf and Hein 2000),
+// where phi is the ratio of recombination to mutation rates.
+func correlationModel(l float64, params []float64) float64 {
+	theta, phi, f := params[0], params[1], params[2]
+	rho := 2 * phi * theta * f * (1 - math.Exp(-l/f))
+	return theta * theta * (rho + 18) / (rho*rho + 13*rho + 18)
+}
+
+// Run is the main function.
+func (cmd *cmdFit) Run() {
+	points := cmd.read()
+	if len(points) <= len(fitParamNames) {
+		log.Fatalf("Too few lags (%d) to fit.\n", len(points))
+	}
+
+	res := fitProfile(points, nil)
+	if !res.Success {
+		log.Println("Fitting did not converge.")
+	}
+
+	var lo, hi []float64
+	if cmd.bootstrapper != nil && res.Success {
+		lo, hi = cmd.confidenceIntervals(points, res)
+	}
+
+	var w *os.File
+	if cmd.outFile != "" {
+		w = createFile(cmd.outFile)
+		defer w.Close()
+	} else {
+		w = os.Stdout
+	}
+
+	w.WriteString("name\testimate\tlo\thi\n")
+	for i, name := range fitParamNames {
+		if lo != nil {
+			w.WriteString(fmt.Sprintf("%s\t%g\t%g\t%g\n", name, res.Params[i], lo[i], hi[i]))
+		} else {
+			w.WriteString(fmt.Sprintf("%s\t%g\tNaN\tNaN\n", name, res.Params[i]))
+		}
+	}
+	w.WriteString(fmt.Sprintf("chi2\t%g\tNaN\tNaN\n", res.Chi2))
+	w.WriteString(fmt.Sprintf("dof\t%d\tNaN\tNaN\n", res.Dof))
+	w.WriteString(fmt.Sprintf("reduced_chi2\t%g\tNaN\tNaN\n", res.Chi2/float64(res.Dof)))
+	w.WriteString(fmt.Sprintf("r2\t%g\tNaN\tNaN\n", res.R2))
+}
+
+// read reads the correlation profile from ct or cr output.
+// In ct output, lags (or ranges of binned lags) are followed by statistics,
+// each of which has mean, variance and n,
+// optionally followed by its confidence interval,
+// and optionally by correlations of covariance statistics;
+// in cr output, the covariance has mean and variance, and n is the 8th column.
+func (cmd *cmdFit) read() (points []profilePoint) {
+	f := openFile(cmd.inFile)
+	defer f.Close()
+
+	statIndex := -1
+	for i, name := range cmd.stats {
+		if name == cmd.stat {
+			statIndex = i
+		}
+	}
+	if cmd.format == "ct" && statIndex < 0 {
+		log.Fatalf("Can not find the statistic %s in %v\n", cmd.stat, cmd.stats)
+	}
+	if cmd.binned && cmd.format != "ct" {
+		log.Fatalln("Only ct output can be binned.")
+	}
+
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadString('\n')
+		terms := strings.Fields(line)
+		if len(terms) > 0 {
+			var l, end, mean, variance, n float64
+			switch cmd.format {
+			case "ct":
+				// lag columns, and statistics, each of 3 or 5 columns.
+				lagColumns := 1
+				if cmd.binned {
+					lagColumns = 2
+				}
+				corrColumns := 0
+				if cmd.corr {
+					corrColumns = 2 * len(correlated(cmd.stats))
+				}
+				width := (len(terms) - lagColumns - corrColumns) / len(cmd.stats)
+				k := lagColumns + statIndex*width
+				l, mean, variance, n = parseFloat(terms[0]), parseFloat(terms[k]), parseFloat(terms[k+1]), parseFloat(terms[k+2])
+				end = l + 1
+				if cmd.binned {
+					end = parseFloat(terms[1])
+				}
+			case "cr":
+				l, mean, variance, n = parseFloat(terms[0]), parseFloat(terms[1]), parseFloat(terms[2]), parseFloat(terms[7])
+				end = l + 1
+			default:
+				log.Fatalf("Can not recognize the format: %s\n", cmd.format)
+			}
+
+			inRange := l >= float64(cmd.minl) && (cmd.maxl <= 0 || l < float64(cmd.maxl))
+			if inRange && n > 1 && variance > 0 && !math.IsNaN(mean) {
+				points = append(points, profilePoint{L: l, End: end, Y: mean, SE: math.Sqrt(variance / n)})
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Fatalln(err)
+			}
+			break
+		}
+	}
+	return
+}
+
+func parseFloat(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return v
+}
+
+// confidenceIntervals refits profiles simulated from the fitted model
+// with the standard errors of lags (parametric bootstrap).
+func (cmd *cmdFit) confidenceIntervals(points []profilePoint, res fitResult) (lo, hi []float64) {
+	b := cmd.bootstrapper
+	replicates := make([][]float64, len(res.Params))
+	simulated := make([]profilePoint, len(points))
+	for r := 0; r < b.replicates; r++ {
+		for i, p := range points {
+			simulated[i] = p
+			simulated[i].Y = p.expected(res.Params) + p.SE*b.rng.NormFloat64()
+		}
+		sim := fitProfile(simulated, res.Params)
+		if sim.Success {
+			for i, v := range sim.Params {
+				replicates[i] = append(replicates[i], v)
+			}
+		}
+	}
+
+	for i := range replicates {
+		l, h := b.percentiles(replicates[i])
+		lo = append(lo, l)
+		hi = append(hi, h)
+	}
+	return
+}
+
+// fitProfile fits the model by weighted nonlinear least squares,
+// weighting lags by their inverse variances.
+// Without initial parameters, it starts from a grid of phi and f
+// and keeps the best fit.
+func fitProfile(points []profilePoint, init []float64) (best fitResult) {
+	starts := [][]float64{}
+	if init != nil {
+		starts = append(starts, init)
+	} else {
+		theta := math.Sqrt(math.Abs(points[0].Y))
+		if theta == 0 {
+			theta = 1e-3
+		}
+		for _, phi := range []float64{0.1, 1, 10} {
+			for _, f := range []float64{10, 100, 1000} {
+				starts = append(starts, []float64{theta, phi, f})
+			}
+		}
+	}
+
+	best.Chi2 = math.Inf(1)
+	for _, start := range starts {
+		res := levenbergMarquardt(points, start)
+		if res.Success && res.Chi2 < best.Chi2 {
+			best = res
+		}
+	}
+
+	if !best.Success {
+		best.Params = starts[0]
+		best.Chi2 = weightedChi2(points, logParams(starts[0]))
+	}
+	best.Dof = len(points) - len(fitParamNames)
+
+	// weighted coefficient of determination.
+	var sw, swy float64
+	for _, p := range points {
+		w := 1 / (p.SE * p.SE)
+		sw += w
+		swy += w * p.Y
+	}
+	var sst float64
+	for _, p := range points {
+		d := p.Y - swy/sw
+		sst += d * d / (p.SE * p.SE)
+	}
+	best.R2 = 1 - best.Chi2/sst
+
+	return
+}
+
+// levenbergMarquardt minimizes chi2 over log parameters,
+// which keeps parameters positive.
+func levenbergMarquardt(points []profilePoint, init []float64) (res fitResult) {
+	x := logParams(init)
+	chi2 := weightedChi2(points, x)
+	lambda := 1e-3
+	np := len(x)
+	for iter := 0; iter < 200; iter++ {
+		r := residuals(points, x)
+		jac := jacobian(points, x)
+
+		// normal equations (J'J + lambda diag(J'J)) dx = -J'r.
+		a := make([][]float64, np)
+		g := make([]float64, np)
+		for i := 0; i < np; i++ {
+			a[i] = make([]float64, np)
+			for k := range r {
+				g[i] -= jac[k][i] * r[k]
+				for j := 0; j < np; j++ {
+					a[i][j] += jac[k][i] * jac[k][j]
+				}
+			}
+		}
+
+		improved := false
+		for !improved && lambda < 1e10 {
+			m := make([][]float64, np)
+			for i := range a {
+				m[i] = append([]float64{}, a[i]...)
+				m[i][i] += lambda * math.Max(a[i][i], 1e-12)
+			}
+			dx, ok := solveLinear(m, g)
+			if !ok {
+				lambda *= 10
+				continue
+			}
+			x1 := make([]float64, np)
+			for i := range x {
+				x1[i] = x[i] + dx[i]
+			}
+			chi21 := weightedChi2(points, x1)
+			if chi21 < chi2 {
+				converged := chi2-chi21 < 1e-10*chi2
+				x, chi2 = x1, chi21
+				lambda /= 10
+				improved = true
+				if converged {
+					return fitResult{Params: expParams(x), Chi2: chi2, Success: true}
+				}
+			} else {
+				lambda *= 10
+			}
+		}
+
+		if !improved {
+			// no step reduces chi2: a (local) minimum.
+			return fitResult{Params: expParams(x), Chi2: chi2, Success: !math.IsNaN(chi2)}
+		}
+	}
+
+	return fitResult{Params: expParams(x), Chi2: chi2, Success: false}
+}
+
+func logParams(params []float64) []float64 {
+	x := make([]float64, len(params))
+	for i, v := range params {
+		x[i] = math.Log(v)
+	}
+	return x
+}
+
+func expParams(x []float64) []float64 {
+	params := make([]float64, len(x))
+	for i, v := range x {
+		params[i] = math.Exp(v)
+	}
+	return params
+}
+
+// residuals returns weighted residuals at log parameters x.
+func residuals(points []profilePoint, x []float64) []float64 {
+	params := expParams(x)
+	r := make([]float64, len(points))
+	for i, p := range points {
+		r[i] = (p.Y - p.expected(params)) / p.SE
+	}
+	return r
+}
+
+func weightedChi2(points []profilePoint, x []float64) float64 {
+	var chi2 float64
+	for _, r := range residuals(points, x) {
+		chi2 += r * r
+	}
+	if math.IsNaN(chi2) {
+		return math.Inf(1)
+	}
+	return chi2
+}
+
+// jacobian returns derivatives of residuals by central differences.
+func jacobian(points []profilePoint, x []float64) [][]float64 {
+	jac := make([][]float64, len(points))
+	for i := range jac {
+		jac[i] = make([]float64, len(x))
+	}
+	h := 1e-6
+	for j := range x {
+		x1 := append([]float64{}, x...)
+		x2 := append([]float64{}, x...)
+		x1[j] += h
+		x2[j] -= h
+		r1, r2 := residuals(points, x1), residuals(points, x2)
+		for i := range points {
+			jac[i][j] = (r1[i] - r2[i]) / (2 * h)
+		}
+	}
+	return jac
+}
+
+// solveLinear solves m x = b by Gaussian elimination with partial pivoting.
+func solveLinear(m [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = append(append([]float64{}, m[i]...), b[i])
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+		if math.Abs(a[p][c]) < 1e-300 {
+			return nil, false
+		}
+		a[c], a[p] = a[p], a[c]
+		for r := c + 1; r < n; r++ {
+			k := a[r][c] / a[c][c]
+			for j := c; j <= n; j++ {
+				a[r][j] -= k * a[c][j]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		s := a[i][n]
+		for j := i + 1; j < n; j++ {
+			s -= a[i][j] * x[j]
+		}
+		x[i] = s / a[i][i]
+	}
+	return x, true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/mingzhi/biogo/feat/gff"
+)
+
+// Modes of grouping sites:
+// by chuncks of the genome, or by genes (CDS records).
+const (
+	byChunck = "chunck"
+	byGene   = "gene"
+)
+
+// geneGroup contains items (SNPs or pi) at positions of a gene.
+type geneGroup struct {
+	id         string
+	start, end int // 0-based, end exclusive.
+	items      []interface{}
+}
+
+// geneGrouper groups items of ordered positions into genes,
+// which may overlap.
+type geneGrouper struct {
+	genes  []*geneGroup // sorted by start.
+	next   int
+	active []*geneGroup
+}
+
+func newGeneGrouper(gffs []*gff.Record) *geneGrouper {
+	g := geneGrouper{}
+	for _, r := range gffs {
+		g.genes = append(g.genes, &geneGroup{id: gffID(r), start: r.Start - 1, end: r.End})
+	}
+	sort.Sort(byGeneStart(g.genes))
+	return &g
+}
+
+// Add adds an item at a position,
+// and returns genes ending before the position.
+func (g *geneGrouper) Add(pos int, item interface{}) (done []*geneGroup) {
+	done = g.finish(pos)
+	for g.next < len(g.genes) && g.genes[g.next].start <= pos {
+		g.active = append(g.active, g.genes[g.next])
+		g.next++
+	}
+	for _, gene := range g.active {
+		if pos < gene.end {
+			gene.items = append(gene.items, item)
+		}
+	}
+	return
+}
+
+// Flush returns the remaining genes.
+func (g *geneGrouper) Flush() []*geneGroup {
+	return g.finish(-1)
+}
+
+// finish removes genes ending before pos, or all if pos is negative,
+// and returns those having items.
+func (g *geneGrouper) finish(pos int) (done []*geneGroup) {
+	active := g.active[:0]
+	for _, gene := range g.active {
+		if pos < 0 || gene.end <= pos {
+			if len(gene.items) > 0 {
+				done = append(done, gene)
+			}
+		} else {
+			active = append(active, gene)
+		}
+	}
+	g.active = active
+	return
+}
+
+type byGeneStart []*geneGroup
+
+func (s byGeneStart) Len() int           { return len(s) }
+func (s byGeneStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byGeneStart) Less(i, j int) bool { return s[i].start < s[j].start }
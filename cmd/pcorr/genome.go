@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mingzhi/biogo/feat/gff"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// genomeSource looks up genome sequences and GFF records of references.
+// The fasta and GFF paths are either directories containing
+// <ref>.fna and <ref>.gff files,
+// or (multi-record) fasta and GFF files.
+// Sequences and records are looked up by reference names,
+// and a genome of another name is never used for a reference.
+// The fasta path is empty if only GFF records are needed.
+type genomeSource struct {
+	fastaPath, gffPath string
+
+	genomes map[string][]byte
+	gffs    map[string][]*gff.Record
+	warned  map[string]bool // references warned of mismatched genome names.
+}
+
+func newGenomeSource(fastaPath, gffPath string) *genomeSource {
+	g := genomeSource{fastaPath: fastaPath, gffPath: gffPath}
+	if fastaPath != "" && !isDir(fastaPath) {
+		g.genomes = readGenomes(fastaPath)
+	}
+	if !isDir(gffPath) {
+		g.gffs = make(map[string][]*gff.Record)
+		for _, r := range readGff(gffPath) {
+			g.gffs[r.SeqName] = append(g.gffs[r.SeqName], r)
+		}
+	}
+	return &g
+}
+
+// Genome returns the sequence of a reference, or nil if it is not found.
+func (g *genomeSource) Genome(ref string) []byte {
+	if g.fastaPath == "" {
+		return nil
+	}
+	if g.genomes == nil {
+		filename := filepath.Join(g.fastaPath, ref+".fna")
+		if _, err := os.Stat(filename); err != nil {
+			return nil
+		}
+		return g.lookup(readGenomes(filename), ref)
+	}
+
+	return g.lookup(g.genomes, ref)
+}
+
+// lookup returns the genome of a reference in genomes,
+// and warns once if genomes contain a single genome of another name.
+func (g *genomeSource) lookup(genomes map[string][]byte, ref string) []byte {
+	genome, found := genomes[ref]
+	if !found && len(genomes) == 1 && !g.warned[ref] {
+		if g.warned == nil {
+			g.warned = make(map[string]bool)
+		}
+		g.warned[ref] = true
+		for id := range genomes {
+			log.Printf("Genome %s does not match reference %s, skip it.\n", id, ref)
+		}
+	}
+	return genome
+}
+
+// Gffs returns the GFF records (CDS) of a reference.
+func (g *genomeSource) Gffs(ref string) []*gff.Record {
+	if g.gffs == nil {
+		filename := filepath.Join(g.gffPath, ref+".gff")
+		if _, err := os.Stat(filename); err != nil {
+			return nil
+		}
+		return readGff(filename)
+	}
+
+	return g.gffs[ref]
+}
+
+// Profile returns the position profile of a reference,
+// or nil if its genome is not found.
+func (g *genomeSource) Profile(ref string, codonTable *taxonomy.GeneticCode) []profiling.Pos {
+	genome := g.Genome(ref)
+	if genome == nil {
+		return nil
+	}
+	return profiling.ProfileGenome(genome, g.Gffs(ref), codonTable)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -20,6 +20,7 @@ var (
 	pileupMinMQ     = pileupApp.Flag("min-MQ", "minimum mapping quality").Short('q').Default("0").Int()
 	pileupOutFile   = pileupApp.Flag("outfile", "output file").Short('o').Default("").String()
 	pileupFastaFile = pileupApp.Flag("fastafile", "genome fasta file").Short('f').Default("").String()
+	pileupSplit     = pileupApp.Flag("split", "write one output file per reference").Bool()
 	pileupBamFile   = pileupApp.Arg("bamfile", "bam file of reads").Required().String()
 
 	piApp         = app.Command("pi", "calculate pi")
@@ -29,12 +30,28 @@ var (
 	piOutFile     = piApp.Flag("output", "output file").Short('o').Default("").String()
 	piRegionStart = piApp.Flag("region-start", "region start").Short('S').Default("0").Int()
 	piRegionEnd   = piApp.Flag("region-end", "region end").Short('E').Default("0").Int()
+	piWindow      = piApp.Flag("window", "window size of diversity statistics").Default("0").Int()
+	piStep        = piApp.Flag("step", "step size of windows (default window size)").Default("0").Int()
+	piGffFile     = piApp.Flag("gff", "GFF file for selecting sites and per-gene diversity statistics").Default("").String()
+	piGeneWindows = piApp.Flag("gene-windows", "write diversity statistics of each GFF record into the window output").Bool()
+	piWindowFile  = piApp.Flag("window-output", "output file of diversity statistics").Default("").String()
+	piEstimator   = piApp.Flag("estimator", "pi estimator (naive or binomial)").Default("naive").String()
+	piAlpha       = piApp.Flag("alpha", "significance level of the binomial error test").Default("0.001").Float64()
+	piRarefy      = piApp.Flag("rarefy", "rarefy sites to a fixed depth").Default("0").Int()
+	piSeed        = piApp.Flag("seed", "random seed for resampling bases (0 for the analytic expectation)").Default("0").Int64()
+	piBootstrap   = piApp.Flag("bootstrap", "number of bootstrap replicates for window statistics").Default("0").Int()
+	piBootSeed    = piApp.Flag("bootstrap-seed", "random seed for bootstrapping").Default("1").Int64()
+	piBootBlock   = piApp.Flag("bootstrap-block", "length of blocks of contiguous sites resampled for window statistics").Default("1000").Int()
+	piSites       = piApp.Flag("sites", "site selector, e.g. \"fourfold & gene_set(core.txt)\"").Default("").String()
+	piFastaFile   = piApp.Flag("fastafile", "genome fasta file or directory for selecting sites").Short('f').Default("").String()
+	piCodonID     = piApp.Flag("codon", "codon table ID").Default("11").String()
 	piPileupFile  = piApp.Arg("pileupfile", "pileup file").Required().String()
 
 	ctApp           = app.Command("ct", "calculate total correlation")
 	ctCondonTableID = ctApp.Flag("codon", "condon table ID").Default("11").String()
 	ctMaxL          = ctApp.Flag("maxl", "max length of correlation").Default("100").Int()
 	ctPos           = ctApp.Flag("pos", "position").Default("4").Int()
+	ctSites         = ctApp.Flag("sites", "site selector, overriding --pos, e.g. \"coding & !mask.bed\"").Default("").String()
 	ctMinCoverage   = ctApp.Flag("min-coverage", "minimum read coverage").Default("10").Int()
 	ctRegionStart   = ctApp.Flag("region-start", "region start").Default("0").Int()
 	ctRegionEnd     = ctApp.Flag("region-end", "region end").Default("0").Int()
@@ -44,17 +61,79 @@ var (
 	ctGffFile       = ctApp.Arg("gff", "GFF file").Required().String()
 	ctOutFile       = ctApp.Arg("out", "output file").Required().String()
 	ctPileupFormat  = ctApp.Flag("pileup-format", "pileup format").Short('F').Default("tab").String()
+	ctBootstrap     = ctApp.Flag("bootstrap", "number of bootstrap replicates over chuncks").Default("0").Int()
+	ctBootSeed      = ctApp.Flag("bootstrap-seed", "random seed for bootstrapping").Default("1").Int64()
+	ctEstimator     = ctApp.Flag("estimator", "pi estimator (naive or binomial)").Default("naive").String()
+	ctAlpha         = ctApp.Flag("alpha", "significance level of the binomial error test").Default("0.001").Float64()
+	ctLDFile        = ctApp.Flag("ld", "output file of LD (r^2, D' and sigma_d^2) per lag").Default("").String()
+	ctCkptFile      = ctApp.Flag("checkpoint", "checkpoint file").Default("").String()
+	ctCkptInterval  = ctApp.Flag("checkpoint-interval", "chuncks between checkpoints").Default("10").Int()
+	ctResume        = ctApp.Flag("resume", "resume from the checkpoint, skipping finished references (whose pileup is still read)").Bool()
+	ctSaveFile      = ctApp.Flag("save", "file saving results for pcorr combine (JSON if ending with .json)").Default("").String()
+	ctWeighting     = ctApp.Flag("weighting", "weighting of chuncks (none, pairs or inverse-variance)").Default("none").String()
+	ctBinning       = ctApp.Flag("binning", "bins of lags: linear, log, or a file of \"start end\" lines").Default("linear").String()
+	ctLinearMax     = ctApp.Flag("linear-max", "max lag of single-lag bins before log-spaced bins").Default("100").Int()
+	ctBinsPerDecade = ctApp.Flag("bins-per-decade", "log-spaced bins per ten-fold lags").Default("10").Int()
+	ctBy            = ctApp.Flag("by", "group sites by chunck or gene").Default("chunck").String()
+	ctCorr          = ctApp.Flag("corr", "write correlations and standard errors of covariance statistics").Bool()
+	ctStats         = ctApp.Flag("stats", "comma-separated statistics (cs, cr, ct, r2, dprime, sigma_d2)").Default("cs,cr,ct").String()
+	ctMethod        = ctApp.Flag("method", "method of comparing read pairs (table or pairs)").Default("table").String()
+	ctMaxPairs      = ctApp.Flag("max-pairs", "max read pairs compared per SNP pair by the pairs method, sampled at random (0 for all)").Default("10000").Int()
+	ctSeed          = ctApp.Flag("seed", "random seed for sampling read pairs").Default("1").Int64()
+
+	depthApp         = app.Command("depth", "calculate read depth and coverage")
+	depthMinBQ       = depthApp.Flag("min-BQ", "minimum base quality").Short('Q').Default("13").Int()
+	depthMinMQ       = depthApp.Flag("min-MQ", "minimum mapping quality").Short('q').Default("0").Int()
+	depthFormat      = depthApp.Flag("pileup-format", "input format (tab, json or bam)").Short('F').Default("tab").String()
+	depthBinSize     = depthApp.Flag("bin-size", "bin size of depth track").Default("1").Int()
+	depthMinDepths   = depthApp.Flag("min-depth", "minimum depth for breadth of coverage").Default("1", "10").Ints()
+	depthFastaFile   = depthApp.Flag("fastafile", "genome fasta file").Short('f').Default("").String()
+	depthGffFile     = depthApp.Flag("gff", "GFF file for per-feature coverage").Default("").String()
+	depthOutFile     = depthApp.Flag("outfile", "output bedGraph file").Short('o').Default("").String()
+	depthSummaryFile = depthApp.Flag("summary", "summary file").Default("").String()
+	depthFeatureFile = depthApp.Flag("feature-output", "per-feature coverage file").Default("").String()
+	depthInFile      = depthApp.Arg("infile", "bam or pileup file").Required().String()
 
 	crApp           = app.Command("cr", "calculate total correlation")
 	crCondonTableID = crApp.Flag("codon", "condon table ID").Default("11").String()
 	crMaxL          = crApp.Flag("maxl", "max length of correlation").Default("100").Int()
 	crPos           = crApp.Flag("pos", "position").Default("4").Int()
+	crSites         = crApp.Flag("sites", "site selector, overriding --pos, e.g. \"coding & !mask.bed\"").Default("").String()
 	crMinCoverage   = crApp.Flag("min-coverage", "minimum read coverage").Default("10").Int()
 	crRegionStart   = crApp.Flag("region-start", "region start").Default("0").Int()
 	crRegionEnd     = crApp.Flag("region-end", "region end").Default("0").Int()
 	crChunckSize    = crApp.Flag("chunck-size", "chunck size").Default("10000").Int()
 	crPrefix        = crApp.Arg("prefix", "prefix").Required().String()
+	crEstimator     = crApp.Flag("estimator", "pi estimator (naive or binomial)").Default("naive").String()
+	crAlpha         = crApp.Flag("alpha", "significance level of the binomial error test").Default("0.001").Float64()
+	crRarefy        = crApp.Flag("rarefy", "rarefy sites to a fixed depth").Default("0").Int()
+	crSeed          = crApp.Flag("seed", "random seed for resampling bases (0 for the analytic expectation)").Default("0").Int64()
+	crBootstrap     = crApp.Flag("bootstrap", "number of bootstrap replicates over chuncks").Default("0").Int()
+	crBootSeed      = crApp.Flag("bootstrap-seed", "random seed for bootstrapping").Default("1").Int64()
+	crWeighting     = crApp.Flag("weighting", "weighting of chuncks (none, pairs or inverse-variance)").Default("none").String()
+	crBy            = crApp.Flag("by", "group sites by chunck or gene").Default("chunck").String()
+	crCorr          = crApp.Flag("corr", "write correlations and standard errors").Bool()
 	crGenomeDir     = crApp.Arg("genome-dir", "genome directory").Required().String()
+
+	combineApp      = app.Command("combine", "combine saved ct results")
+	combineOutFile  = combineApp.Flag("outfile", "output file").Short('o').Required().String()
+	combineLDFile   = combineApp.Flag("ld", "output file of LD").Default("").String()
+	combineCorr     = combineApp.Flag("corr", "write correlations and standard errors of covariance statistics").Bool()
+	combineSaveFile = combineApp.Flag("save", "file saving the combined results").Default("").String()
+	combineInFiles  = combineApp.Arg("infiles", "saved ct results").Required().Strings()
+
+	fitApp       = app.Command("fit", "fit correlation profiles")
+	fitFormat    = fitApp.Flag("format", "input format (ct or cr)").Default("ct").String()
+	fitStat      = fitApp.Flag("stat", "statistic of ct output to fit").Default("ct").String()
+	fitCorr      = fitApp.Flag("corr", "ct output with correlations, as by ct --corr").Bool()
+	fitStats     = fitApp.Flag("stats", "comma-separated statistics of ct output, as by ct --stats").Default("cs,cr,ct").String()
+	fitMinL      = fitApp.Flag("minl", "min lag").Default("1").Int()
+	fitMaxL      = fitApp.Flag("maxl", "max lag (0 for all)").Default("0").Int()
+	fitBinned    = fitApp.Flag("binned", "ct output of binned lags").Bool()
+	fitBootstrap = fitApp.Flag("bootstrap", "number of bootstrap replicates").Default("100").Int()
+	fitBootSeed  = fitApp.Flag("bootstrap-seed", "random seed for bootstrapping").Default("1").Int64()
+	fitOutFile   = fitApp.Flag("outfile", "output file").Short('o').Default("").String()
+	fitInFile    = fitApp.Arg("infile", "ct or cr output file").Required().String()
 )
 
 func main() {
@@ -82,6 +161,8 @@ func main() {
 		pileupCmd := cmdPileup{
 			minBQ:     *pileupMinBQ,
 			minMQ:     *pileupMinMQ,
+			ncpu:      *ncpu,
+			split:     *pileupSplit,
 			outFile:   *pileupOutFile,
 			fastaFile: *pileupFastaFile,
 			bamFile:   *pileupBamFile,
@@ -96,6 +177,27 @@ func main() {
 			debug:        *debug,
 			minCoverage:  *piMinCoverage,
 			pileupFormat: *piFormat,
+			window:       *piWindow,
+			step:         *piStep,
+			gffFile:      *piGffFile,
+			geneWindows:  *piGeneWindows,
+			windowFile:   *piWindowFile,
+			errModel:     newErrorModel(*piEstimator, *piAlpha),
+		}
+		if *piSites != "" {
+			piCmd.sites = newSiteSelector(*piSites, 0)
+			piCmd.fastaFile = *piFastaFile
+			piCmd.codonTableID = *piCodonID
+		}
+		if *piRarefy > 0 {
+			piCmd.rarefier = newRarefier(*piRarefy, *piSeed)
+		}
+		if *piBootstrap > 0 {
+			piCmd.bootstrapper = newBootstrapper(*piBootstrap, *piBootSeed)
+			piCmd.blockSize = *piBootBlock
+			if piCmd.blockSize <= 0 {
+				log.Fatalln("Bootstrap block length must be positive.")
+			}
 		}
 		piCmd.regionStart = *piRegionStart
 		piCmd.regionEnd = *piRegionEnd
@@ -104,18 +206,37 @@ func main() {
 	case ctApp.FullCommand():
 		runtime.GOMAXPROCS(*ncpu)
 		ctCmd := cmdCt{
-			codonTableID: *ctCondonTableID,
-			maxl:         *ctMaxL,
-			pos:          *ctPos,
-			minCoverage:  *ctMinCoverage,
-			regionStart:  *ctRegionStart,
-			regionEnd:    *ctRegionEnd,
-			chunckSize:   *ctChunckSize,
-			pileupFile:   *ctPileupFile,
-			fastaFile:    *ctFastaFile,
-			gffFile:      *ctGffFile,
-			outFile:      *ctOutFile,
-			debug:        *debug,
+			codonTableID:       *ctCondonTableID,
+			maxl:               *ctMaxL,
+			pos:                *ctPos,
+			sites:              newSiteSelector(*ctSites, *ctPos),
+			minCoverage:        *ctMinCoverage,
+			regionStart:        *ctRegionStart,
+			regionEnd:          *ctRegionEnd,
+			chunckSize:         *ctChunckSize,
+			method:             *ctMethod,
+			maxPairs:           *ctMaxPairs,
+			seed:               *ctSeed,
+			pileupFile:         *ctPileupFile,
+			fastaFile:          *ctFastaFile,
+			gffFile:            *ctGffFile,
+			outFile:            *ctOutFile,
+			ldFile:             *ctLDFile,
+			checkpointFile:     *ctCkptFile,
+			checkpointInterval: *ctCkptInterval,
+			resume:             *ctResume,
+			by:                 *ctBy,
+			saveFile:           *ctSaveFile,
+			weighting:          *ctWeighting,
+			stats:              splitNames(*ctStats),
+			corr:               *ctCorr,
+			pileupFormat:       *ctPileupFormat,
+			errModel:           newErrorModel(*ctEstimator, *ctAlpha),
+			debug:              *debug,
+		}
+		ctCmd.setBins(newLagBins(*ctBinning, *ctMaxL, *ctLinearMax, *ctBinsPerDecade))
+		if *ctBootstrap > 0 {
+			ctCmd.bootstrapper = newBootstrapper(*ctBootstrap, *ctBootSeed)
 		}
 		ctCmd.Run()
 		break
@@ -124,14 +245,69 @@ func main() {
 			codonTableID: *crCondonTableID,
 			maxl:         *crMaxL,
 			pos:          *crPos,
+			sites:        newSiteSelector(*crSites, *crPos),
 			minCoverage:  *crMinCoverage,
 			regionStart:  *crRegionStart,
 			regionEnd:    *crRegionEnd,
 			chunckSize:   *crChunckSize,
 			genomeDir:    *crGenomeDir,
 			prefix:       *crPrefix,
+			errModel:     newErrorModel(*crEstimator, *crAlpha),
+			by:           *crBy,
+			weighting:    *crWeighting,
+			corr:         *crCorr,
+		}
+		if *crBootstrap > 0 {
+			crCmd.bootstrapper = newBootstrapper(*crBootstrap, *crBootSeed)
+		}
+		if *crRarefy > 0 {
+			crCmd.rarefier = newRarefier(*crRarefy, *crSeed)
 		}
 		crCmd.Run()
 		break
+	case depthApp.FullCommand():
+		depthCmd := cmdDepth{
+			debug:       *debug,
+			inFile:      *depthInFile,
+			format:      *depthFormat,
+			outFile:     *depthOutFile,
+			summaryFile: *depthSummaryFile,
+			featureFile: *depthFeatureFile,
+			fastaFile:   *depthFastaFile,
+			gffFile:     *depthGffFile,
+			minBQ:       *depthMinBQ,
+			minMQ:       *depthMinMQ,
+			binSize:     *depthBinSize,
+			minDepths:   *depthMinDepths,
+		}
+		depthCmd.Run()
+		break
+	case combineApp.FullCommand():
+		combineCmd := cmdCombine{
+			inFiles:  *combineInFiles,
+			outFile:  *combineOutFile,
+			ldFile:   *combineLDFile,
+			saveFile: *combineSaveFile,
+			corr:     *combineCorr,
+		}
+		combineCmd.Run()
+		break
+	case fitApp.FullCommand():
+		fitCmd := cmdFit{
+			inFile:  *fitInFile,
+			outFile: *fitOutFile,
+			format:  *fitFormat,
+			stat:    *fitStat,
+			stats:   splitNames(*fitStats),
+			corr:    *fitCorr,
+			minl:    *fitMinL,
+			maxl:    *fitMaxL,
+			binned:  *fitBinned,
+		}
+		if *fitBootstrap > 0 {
+			fitCmd.bootstrapper = newBootstrapper(*fitBootstrap, *fitBootSeed)
+		}
+		fitCmd.Run()
+		break
 	}
 }
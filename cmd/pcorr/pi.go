@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"log"
 	"os"
+
+	"github.com/mingzhi/biogo/feat/gff"
+	"github.com/mingzhi/ncbiftp/taxonomy"
 )
 
 type cmdPi struct {
@@ -15,6 +19,19 @@ type cmdPi struct {
 	regionStart, regionEnd int
 	minCoverage            int
 	pileupFormat           string
+	errModel               errorModel
+	sites                  *siteSelector
+	fastaFile              string
+	codonTableID           string
+	rarefier               *rarefier
+	bootstrapper           *bootstrapper
+	blockSize              int // length of blocks of sites resampled by bootstrapper.
+
+	// diversity statistics over windows or GFF records.
+	window, step int
+	gffFile      string
+	geneWindows  bool // write statistics of GFF records.
+	windowFile   string
 }
 
 type Pi struct {
@@ -22,17 +39,42 @@ type Pi struct {
 	Base    string
 	Pos     int
 	Alleles map[string]int
+	Error   float64 `json:",omitempty"` // mean error probability of bases.
 }
 
 func (p Pi) Pi() (pi float64) {
-	total := 0
-	nums := []int{}
+	return piFromCounts(p.Counts(errorModel{estimator: naiveEstimator}))
+}
+
+// Estimate returns pi excluding alleles explained by sequencing errors.
+func (p Pi) Estimate(m errorModel) float64 {
+	return piFromCounts(p.Counts(m))
+}
+
+// Counts returns the numbers of A, T, G and C bases,
+// excluding alleles explained by sequencing errors.
+func (p Pi) Counts(m errorModel) (nums []int) {
+	counts := make(map[byte]int)
 	for c, n := range p.Alleles {
 		if isATGC(c[0]) {
-			total += n
+			counts[c[0]] += n
+		}
+	}
+
+	errors := m.errorAlleles(counts, p.Error)
+	for b, n := range counts {
+		if !errors[b] {
 			nums = append(nums, n)
 		}
 	}
+	return
+}
+
+func piFromCounts(nums []int) (pi float64) {
+	total := 0
+	for _, n := range nums {
+		total += n
+	}
 
 	cross := 0
 	for i := 0; i < len(nums); i++ {
@@ -50,13 +92,38 @@ func (c *cmdPi) Run() {
 	f := openFile(c.pileupFile)
 	defer f.Close()
 	snpChan := readPileup(f, 0, 0, c.pileupFormat)
+
+	var genomes *genomeSource
+	if c.sites != nil {
+		if c.fastaFile == "" || c.gffFile == "" {
+			log.Fatalln("Fasta and GFF files are required for selecting sites.")
+		}
+	}
+	if c.geneWindows && c.gffFile == "" {
+		log.Fatalln("GFF file is required for per-gene statistics.")
+	}
+	if c.sites != nil || c.gffFile != "" {
+		genomes = newGenomeSource(c.fastaFile, c.gffFile)
+	}
+
 	piChan := make(chan Pi)
 	go func() {
 		defer close(piChan)
+		var ref string
+		var selectSite func(pos int) bool
 		for s := range snpChan {
 			if c.regionEnd > 0 && s.Pos > c.regionEnd {
 				break
 			}
+			if c.sites != nil {
+				if selectSite == nil || s.Ref != ref {
+					ref = s.Ref
+					selectSite = c.selectSites(genomes, ref)
+				}
+				if !selectSite(s.Pos) {
+					continue
+				}
+			}
 			if s.Pos >= c.regionStart {
 				if len(s.Alleles) > 0 {
 					bases := []byte{}
@@ -65,7 +132,7 @@ func (c *cmdPi) Run() {
 						bases = append(bases, a.Base)
 						quals = append(quals, a.Qual)
 					}
-					bases, _ = c.filterBases(bases, quals)
+					bases, quals = c.filterBases(bases, quals)
 					bases = bytes.ToUpper(bases)
 
 					if len(bases) < c.minCoverage {
@@ -82,6 +149,15 @@ func (c *cmdPi) Run() {
 						Base:    string(s.Base),
 						Pos:     s.Pos,
 						Alleles: m,
+						Error:   meanError(quals, qualOffset(c.pileupFormat)),
+					}
+
+					if c.rarefier != nil {
+						var ok bool
+						pi, ok = c.rarefier.Rarefy(pi)
+						if !ok {
+							continue
+						}
 					}
 
 					if c.debug {
@@ -105,11 +181,50 @@ func (c *cmdPi) Run() {
 
 	encoder := json.NewEncoder(w)
 
+	// Diversity statistics over windows or GFF records.
+	var scanner *diversityScanner
+	if c.window > 0 || c.geneWindows || c.windowFile != "" {
+		if c.windowFile == "" {
+			log.Fatalln("Window output file is required for window statistics.")
+		}
+		sw := createFile(c.windowFile)
+		defer sw.Close()
+		scanner = c.diversityScanner(sw, genomes)
+	}
+
 	for pi := range piChan {
 		if err := encoder.Encode(pi); err != nil {
 			log.Fatalln(err)
 		}
+		if scanner != nil {
+			scanner.Add(pi)
+		}
+	}
+	if scanner != nil {
+		scanner.Close()
+	}
+}
+
+// selectSites returns a function selecting sites of a reference.
+func (c *cmdPi) selectSites(genomes *genomeSource, ref string) func(pos int) bool {
+	codonTable := taxonomy.GeneticCodes()[c.codonTableID]
+	profile := genomes.Profile(ref, codonTable)
+	if profile == nil {
+		log.Printf("Can not find the genome of %s, skip it.\n", ref)
+		return func(pos int) bool { return false }
 	}
+	return c.sites.Bind(ref, profile, genomes.Gffs(ref))
+}
+
+// diversityScanner returns a scanner writing diversity statistics into w,
+// over windows, and GFF records of genomes if per-gene statistics are asked for.
+func (c *cmdPi) diversityScanner(w io.Writer, genomes *genomeSource) *diversityScanner {
+	var gffs func(ref string) []*gff.Record
+	if c.geneWindows {
+		gffs = genomes.Gffs
+	}
+
+	return newDiversityScanner(w, c.window, c.step, gffs, c.errModel, c.bootstrapper, c.blockSize)
 }
 
 func (c *cmdPi) filterBases(bases []byte, quals []byte) (bases1, quals1 []byte) {
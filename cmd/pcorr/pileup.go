@@ -3,9 +3,12 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
+	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	. "github.com/mingzhi/pileup"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"sort"
@@ -14,18 +17,30 @@ import (
 type cmdPileup struct {
 	debug                       bool
 	minBQ, minMQ                int
+	ncpu                        int
+	split                       bool
 	bamFile, fastaFile, outFile string
 }
 
 func (cmd *cmdPileup) Run() {
+	// With an indexed bam file,
+	// references can be piled up independently.
+	if cmd.ncpu > 1 || cmd.split {
+		if _, err := os.Stat(cmd.bamFile + ".bai"); err == nil {
+			cmd.runParallel()
+			return
+		}
+		log.Printf("Can not find the index of %s, pile up references in order.\n", cmd.bamFile)
+	}
+
 	_, readChan := readBamFile(cmd.bamFile)
 	filteredReadChan := cmd.filterReads(readChan)
 	mappedReadChan := cmd.mapReads(filteredReadChan)
-	genome := []byte{}
+	genomes := make(map[string][]byte)
 	if cmd.fastaFile != "" {
-		genome = readGenome(cmd.fastaFile)
+		genomes = readGenomes(cmd.fastaFile)
 	}
-	snpChan := cmd.pileupReads(mappedReadChan, genome)
+	snpChan := cmd.pileupReads(mappedReadChan, genomes)
 	var f *os.File
 	if cmd.outFile != "" {
 		f = createFile(cmd.outFile)
@@ -37,6 +52,105 @@ func (cmd *cmdPileup) Run() {
 	cmd.writeSNP(snpChan, f)
 }
 
+// runParallel piles up references with a pool of ncpu workers.
+// Each worker writes the SNPs of a reference into its own file,
+// which is either kept as a per-reference output,
+// or copied into the output in the order of references.
+func (cmd *cmdPileup) runParallel() {
+	if cmd.split && cmd.outFile == "" {
+		log.Fatalln("Output file is required for per-reference outputs.")
+	}
+
+	header := readBamHeader(cmd.bamFile)
+	idx := readBamIndex(cmd.bamFile + ".bai")
+	genomes := make(map[string][]byte)
+	if cmd.fastaFile != "" {
+		genomes = readGenomes(cmd.fastaFile)
+	}
+
+	type job struct {
+		i   int
+		ref *sam.Reference
+	}
+	refs := header.Refs()
+	jobChan := make(chan job)
+	go func() {
+		defer close(jobChan)
+		for i, ref := range refs {
+			jobChan <- job{i: i, ref: ref}
+		}
+	}()
+
+	// results[i] receives the file name of the ith reference.
+	results := make([]chan string, len(refs))
+	for i := range results {
+		results[i] = make(chan string, 1)
+	}
+	ncpu := cmd.ncpu
+	if ncpu < 1 {
+		ncpu = 1
+	}
+	for i := 0; i < ncpu; i++ {
+		go func() {
+			for j := range jobChan {
+				results[j.i] <- cmd.pileupRef(j.ref, idx, genomes)
+			}
+		}()
+	}
+
+	if cmd.split {
+		for i := range results {
+			filename := <-results[i]
+			if cmd.debug {
+				log.Printf("Finished %s\n", filename)
+			}
+		}
+		return
+	}
+
+	var w *os.File
+	if cmd.outFile != "" {
+		w = createFile(cmd.outFile)
+	} else {
+		w = os.Stdout
+	}
+	defer w.Close()
+
+	for i := range results {
+		filename := <-results[i]
+		f := openFile(filename)
+		if _, err := io.Copy(w, f); err != nil {
+			log.Fatalln(err)
+		}
+		f.Close()
+		os.Remove(filename)
+	}
+}
+
+// pileupRef piles up reads of a reference,
+// and returns the name of the file where the SNPs were written.
+func (cmd *cmdPileup) pileupRef(ref *sam.Reference, idx *bam.Index, genomes map[string][]byte) string {
+	var f *os.File
+	if cmd.split {
+		f = createFile(fmt.Sprintf("%s.%s", cmd.outFile, ref.Name()))
+	} else {
+		var err error
+		f, err = ioutil.TempFile("", "pileup")
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	defer f.Close()
+
+	readChan := readBamRef(cmd.bamFile, idx, ref)
+	filteredReadChan := cmd.filterReads(readChan)
+	mappedReadChan := cmd.mapReads(filteredReadChan)
+	snpChan := cmd.pileupReads(mappedReadChan, genomes)
+	cmd.writeSNP(snpChan, f)
+
+	return f.Name()
+}
+
 type MappedRead struct {
 	Ref  string
 	ID   string // ID
@@ -46,14 +160,35 @@ type MappedRead struct {
 	MapQ byte
 }
 
-func (cmd *cmdPileup) pileupReads(mappedReadChan chan MappedRead, genome []byte) chan SNP {
+// pileupReads piles up reads sorted by coordinate,
+// whose reference bases are looked up in genomes by reference names.
+func (cmd *cmdPileup) pileupReads(mappedReadChan chan MappedRead, genomes map[string][]byte) chan SNP {
 	c := make(chan SNP)
 	go func() {
 		defer close(c)
 		var currentReadID int
 
 		buffer := make(map[int]*SNP)
+		// flush sends all buffered SNPs in order.
+		flush := func() {
+			positions := []int{}
+			for pos := range buffer {
+				positions = append(positions, pos)
+			}
+			sort.Ints(positions)
+			for _, pos := range positions {
+				c <- *buffer[pos]
+			}
+			buffer = make(map[int]*SNP)
+		}
+
+		var ref string
+		var genome []byte
 		for mr := range mappedReadChan {
+			if mr.Ref != ref {
+				flush()
+				ref, genome = mr.Ref, genomes[mr.Ref]
+			}
 			currentReadID++
 			for i := 0; i < len(mr.Seq); i++ {
 				pos := mr.Pos + i
@@ -102,9 +237,7 @@ func (cmd *cmdPileup) pileupReads(mappedReadChan chan MappedRead, genome []byte)
 			}
 		}
 
-		for _, snp := range buffer {
-			c <- *snp
-		}
+		flush()
 	}()
 
 	return c
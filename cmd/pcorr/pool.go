@@ -0,0 +1,146 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/mingzhi/pileup"
+	"github.com/mingzhi/pileup/calc"
+)
+
+// maxChuncksInFlight is the number of chuncks being calculated,
+// while earlier chuncks are waited for,
+// so that workers do not idle at chunck boundaries.
+const maxChuncksInFlight = 4
+
+// ctPool is a pool of workers for a whole ct run.
+// Workers calculate windows of SNPs,
+// which are the correlations of an anchor SNP with SNPs after it,
+// and accumulate them into their own shards of the chunck of the anchor,
+// so that no locks are needed.
+type ctPool struct {
+	cmd     *cmdCt
+	size    int
+	jobChan chan ctJob
+	wg      sync.WaitGroup
+}
+
+// ctJob is a window of SNPs anchored at the first SNP.
+type ctJob struct {
+	snpArr []*pileup.SNP
+	chunck *ctChunck
+}
+
+// ctChunck is a chunck of anchor SNPs.
+// It is kept only while being calculated.
+type ctChunck struct {
+	end     int                // chunck end.
+	lastPos int                // position of the last anchor SNP.
+	shards  []*calc.Calculator // calculators of workers, nil if unused.
+	pending sync.WaitGroup     // windows submitted but not calculated.
+}
+
+func (cmd *cmdCt) newPool() *ctPool {
+	p := ctPool{cmd: cmd, size: runtime.GOMAXPROCS(0)}
+	p.jobChan = make(chan ctJob, p.size)
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.work(i)
+	}
+	return &p
+}
+
+// work calculates windows in turn by the worker i,
+// which keeps its random pair sampler for the whole run.
+func (p *ctPool) work(i int) {
+	defer p.wg.Done()
+	sampler := newPairSampler(p.cmd.maxPairs, p.cmd.seed+int64(i))
+	for job := range p.jobChan {
+		c := job.chunck
+		if c.shards[i] == nil {
+			c.shards[i] = p.cmd.newCalculator()
+		}
+		p.cmd.calcSNPArr(job.snpArr, c.shards[i], sampler)
+		c.pending.Done()
+	}
+}
+
+// Close stops workers after submitted windows are calculated.
+func (p *ctPool) Close() {
+	close(p.jobChan)
+	p.wg.Wait()
+}
+
+// Submit sends windows anchored at each SNP to workers,
+// and returns chuncks of anchors in order,
+// once all of their windows are submitted.
+// The first chunck ends at chunckEnd, and the others are chunckSize long.
+// Windows cross chunck boundaries,
+// so that SNP pairs at chunck boundaries are not missed.
+func (p *ctPool) Submit(snpChan <-chan *pileup.SNP, chunckEnd, chunckSize int) chan *ctChunck {
+	cc := make(chan *ctChunck, maxChuncksInFlight)
+	go func() {
+		defer close(cc)
+		var c *ctChunck
+		submit := func(arr []*pileup.SNP) {
+			anchor := arr[0]
+			if c == nil || anchor.Pos > c.end {
+				end := chunckEnd
+				if c != nil {
+					cc <- c
+					end = c.end + chunckSize
+				}
+				for anchor.Pos > end && chunckSize > 0 {
+					end += chunckSize
+				}
+				c = &ctChunck{end: end, shards: make([]*calc.Calculator, p.size)}
+			}
+			c.lastPos = anchor.Pos
+			c.pending.Add(1)
+			p.jobChan <- ctJob{snpArr: arr, chunck: c}
+		}
+
+		arr := []*pileup.SNP{}
+		for s := range snpChan {
+			arr = append(arr, s)
+			lag := s.Pos - arr[0].Pos
+			if lag < 0 {
+				p.cmd.panic("SNPs are not in order.")
+			}
+
+			for lag >= p.cmd.maxl {
+				submit(arr)
+				arr = arr[1:]
+				lag = s.Pos - arr[0].Pos
+			}
+		}
+		for len(arr) > 0 {
+			submit(arr)
+			arr = arr[1:]
+		}
+
+		if c != nil {
+			cc <- c
+		}
+	}()
+	return cc
+}
+
+// Wait waits for windows of the chunck,
+// and returns the calculator of shards appended.
+func (c *ctChunck) Wait() *calc.Calculator {
+	c.pending.Wait()
+	var calculator *calc.Calculator
+	for _, shard := range c.shards {
+		if shard == nil {
+			continue
+		}
+		if calculator == nil {
+			calculator = shard
+		} else {
+			calculator.Append(shard)
+		}
+	}
+	c.shards = nil
+	return calculator
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mingzhi/pileup"
+	"github.com/mingzhi/pileup/calc"
+)
+
+// testSNPs returns ordered SNPs of random reads of two alleles.
+func testSNPs(numReads, genomeLen, readLen int) []*pileup.SNP {
+	rng := rand.New(rand.NewSource(1))
+	snps := make([]*pileup.SNP, genomeLen)
+	for pos := range snps {
+		snps[pos] = &pileup.SNP{Ref: "chr1", Pos: pos}
+	}
+	for i := 0; i < numReads; i++ {
+		start := rng.Intn(genomeLen - readLen)
+		for pos := start; pos < start+readLen; pos++ {
+			a := pileup.Allele{Base: "AT"[rng.Intn(2)], QName: fmt.Sprintf("read%d", i)}
+			snps[pos].Alleles = append(snps[pos].Alleles, a)
+		}
+	}
+
+	// keep some sites as SNPs, leaving gaps of chuncks without SNPs.
+	var kept []*pileup.SNP
+	for _, s := range snps {
+		if s.Pos%7 != 0 && (s.Pos < 200 || s.Pos >= 300) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// TestPoolMatchesSerial calculates windows by the pool in chuncks,
+// whose windows cross chunck boundaries,
+// and compares with calculating every window in turn.
+func TestPoolMatchesSerial(t *testing.T) {
+	cmd := &cmdCt{
+		maxl:        30,
+		chunckSize:  50,
+		method:      tableMethod,
+		weighting:   noWeighting,
+		stats:       calc.DefaultStatistics,
+		minCoverage: 2,
+	}
+	cmd.setBins(calc.NewLinearBins(cmd.maxl))
+	snps := testSNPs(400, 500, 40)
+
+	serial := cmd.newCalculator()
+	sampler := newPairSampler(cmd.maxPairs, cmd.seed)
+	for i := range snps {
+		j := i
+		for j < len(snps) && snps[j].Pos-snps[i].Pos < cmd.maxl {
+			j++
+		}
+		cmd.calcSNPArr(snps[i:j], serial, sampler)
+	}
+	if serial.Pairs[1] == 0 {
+		t.Fatal("expect site pairs at lag 1")
+	}
+
+	cmd.pool = cmd.newPool()
+	defer cmd.pool.Close()
+	snpChan := make(chan *pileup.SNP)
+	go func() {
+		defer close(snpChan)
+		for _, s := range snps {
+			snpChan <- s
+		}
+	}()
+
+	pooled := cmd.newCalculator()
+	lastEnd := -1
+	for chunck := range cmd.pool.Submit(snpChan, cmd.chunckSize, cmd.chunckSize) {
+		if chunck.end <= lastEnd || chunck.lastPos > chunck.end || chunck.lastPos <= lastEnd {
+			t.Fatalf("chunck ending at %d (last anchor %d) after chunck ending at %d\n", chunck.end, chunck.lastPos, lastEnd)
+		}
+		lastEnd = chunck.end
+		pooled.Append(chunck.Wait())
+	}
+
+	for k, name := range serial.Names {
+		s, p := serial.Stats[k], pooled.Stat(name)
+		for i := 0; i < serial.Bins.Len(); i++ {
+			if s.N(i) != p.N(i) || math.Abs(s.Result(i)-p.Result(i)) > 1e-9 {
+				t.Errorf("%s at lag %d: expect %g of %d, got %g of %d\n", name, i, s.Result(i), s.N(i), p.Result(i), p.N(i))
+			}
+		}
+	}
+	for i := range serial.Pairs {
+		if serial.Pairs[i] != pooled.Pairs[i] {
+			t.Errorf("lag %d: expect %d site pairs, got %d\n", i, serial.Pairs[i], pooled.Pairs[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// rarefier brings sites to a fixed depth,
+// so that pi is comparable among samples of different coverages.
+//
+// The mean pairwise difference of a subsample drawn without replacement
+// is an unbiased estimate of that of the whole sample,
+// so the expected pi at depth N equals pi at the full depth,
+// and the analytic mode (without a seed) only skips sites below N.
+// This is exact for the naive estimator.
+// With a seed, bases are resampled,
+// which also reproduces the power of the error test at depth N.
+type rarefier struct {
+	depth int
+	rng   *rand.Rand
+}
+
+func newRarefier(depth int, seed int64) *rarefier {
+	r := rarefier{depth: depth}
+	if seed != 0 {
+		r.rng = rand.New(rand.NewSource(seed))
+	}
+	return &r
+}
+
+// Rarefy returns the site at the rarefied depth,
+// and false if it has less than depth bases.
+func (r *rarefier) Rarefy(p Pi) (Pi, bool) {
+	keys := []string{}
+	total := 0
+	for c, n := range p.Alleles {
+		if isATGC(c[0]) && n > 0 {
+			keys = append(keys, c)
+			total += n
+		}
+	}
+	if total < r.depth {
+		return p, false
+	}
+	if r.rng == nil {
+		return p, true
+	}
+
+	// draw depth bases without replacement.
+	sort.Strings(keys)
+	remains := make([]int, len(keys))
+	for i, c := range keys {
+		remains[i] = p.Alleles[c]
+	}
+	alleles := make(map[string]int)
+	for i := 0; i < r.depth; i++ {
+		k := r.rng.Intn(total)
+		j := 0
+		for k >= remains[j] {
+			k -= remains[j]
+			j++
+		}
+		remains[j]--
+		total--
+		alleles[keys[j]]++
+	}
+
+	p.Alleles = alleles
+	return p, true
+}
@@ -65,3 +65,84 @@ func readBamFile(fileName string) (h *sam.Header, c chan sam.Record) {
 
 	return
 }
+
+// readBamHeader reads the header of a bam or sam file.
+func readBamHeader(fileName string) *sam.Header {
+	f, err := os.Open(fileName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	if fileName[len(fileName)-3:] == "bam" {
+		bamReader, err := bam.NewReader(f, 0)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer bamReader.Close()
+		return bamReader.Header()
+	}
+
+	samReader, err := sam.NewReader(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return samReader.Header()
+}
+
+// readBamIndex reads a bam index file.
+func readBamIndex(fileName string) *bam.Index {
+	f, err := os.Open(fileName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	idx, err := bam.ReadIndex(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return idx
+}
+
+// readBamRef reads the records of a reference from an indexed bam file,
+// and returns a channel of sam records.
+func readBamRef(fileName string, idx *bam.Index, ref *sam.Reference) (c chan sam.Record) {
+	c = make(chan sam.Record)
+	go func() {
+		defer close(c)
+
+		f, err := os.Open(fileName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+
+		bamReader, err := bam.NewReader(f, 0)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer bamReader.Close()
+
+		chunks, err := idx.Chunks(ref, 0, ref.Len())
+		if err != nil {
+			// the reference has no reads.
+			return
+		}
+
+		it, err := bam.NewIterator(bamReader, chunks)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer it.Close()
+
+		for it.Next() {
+			c <- *it.Record()
+		}
+		if err := it.Error(); err != nil {
+			log.Fatalln(err)
+		}
+	}()
+
+	return
+}
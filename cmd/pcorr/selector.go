@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/mingzhi/biogo/feat/gff"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// siteSelector selects sites by an expression of site classes, e.g.
+//
+//	fourfold & gene_set(core.txt)
+//	coding & !mask.bed
+//	(first | second) & !bed(repeats.bed)
+//
+// Site classes are noncoding (or intergenic), coding, first, second,
+// third (including four-fold sites), fourfold,
+// and nonsynonymous (first and second codon positions).
+// gene_set(file) selects sites in CDS whose IDs are listed in the file,
+// and bed(file), or a file name ending with .bed,
+// selects sites in the intervals of a BED file.
+// Classes are combined with &, | and !, and grouped by parentheses.
+type siteSelector struct {
+	expr   siteExpr
+	source string // the expression, or the position type.
+}
+
+// siteGenome contains what site classes are evaluated against.
+type siteGenome struct {
+	ref     string
+	profile []profiling.Pos
+	gffs    []*gff.Record
+}
+
+type siteExpr interface {
+	// bind returns a function selecting positions of a genome.
+	bind(g *siteGenome) func(pos int) bool
+}
+
+// newSiteSelector parses the expression,
+// or uses the position type if the expression is empty.
+func newSiteSelector(expr string, pos int) *siteSelector {
+	if expr == "" {
+		return &siteSelector{expr: typeExpr{posType: convertPosType(pos)}, source: strconv.Itoa(pos)}
+	}
+
+	e, err := parseSiteExpr(expr)
+	if err != nil {
+		log.Fatalf("Can not parse site selector %s: %v\n", expr, err)
+	}
+	return &siteSelector{expr: e, source: expr}
+}
+
+// parseSiteExpr parses a whole expression of site classes.
+func parseSiteExpr(expr string) (siteExpr, error) {
+	p := selectorParser{tokens: tokenizeSelector(expr)}
+	e, err := p.parseExpr()
+	if err == nil && p.i < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.i])
+	}
+	return e, err
+}
+
+// String returns the expression, or the position type.
+func (s *siteSelector) String() string {
+	return s.source
+}
+
+// Bind returns a function selecting positions of a genome.
+func (s *siteSelector) Bind(ref string, profile []profiling.Pos, gffs []*gff.Record) func(pos int) bool {
+	return s.expr.bind(&siteGenome{ref: ref, profile: profile, gffs: gffs})
+}
+
+type andExpr struct{ a, b siteExpr }
+type orExpr struct{ a, b siteExpr }
+type notExpr struct{ a siteExpr }
+
+func (e andExpr) bind(g *siteGenome) func(pos int) bool {
+	a, b := e.a.bind(g), e.b.bind(g)
+	return func(pos int) bool { return a(pos) && b(pos) }
+}
+
+func (e orExpr) bind(g *siteGenome) func(pos int) bool {
+	a, b := e.a.bind(g), e.b.bind(g)
+	return func(pos int) bool { return a(pos) || b(pos) }
+}
+
+// bind selects positions of the genome not selected by a,
+// and no positions out of the genome.
+func (e notExpr) bind(g *siteGenome) func(pos int) bool {
+	a := e.a.bind(g)
+	return func(pos int) bool { return pos >= 0 && pos < len(g.profile) && !a(pos) }
+}
+
+// typeExpr selects sites of a position type of the profile.
+type typeExpr struct {
+	posType byte
+}
+
+func (e typeExpr) bind(g *siteGenome) func(pos int) bool {
+	return func(pos int) bool {
+		return pos >= 0 && pos < len(g.profile) && checkPosType(e.posType, g.profile[pos].Type)
+	}
+}
+
+// geneSetExpr selects sites in CDS of a gene set.
+type geneSetExpr struct {
+	ids map[string]bool
+}
+
+func (e geneSetExpr) bind(g *siteGenome) func(pos int) bool {
+	mask := make([]bool, len(g.profile))
+	for _, r := range g.gffs {
+		if e.ids[gffID(r)] {
+			fillMask(mask, r.Start-1, r.End)
+		}
+	}
+	return maskFunc(mask)
+}
+
+// bedExpr selects sites in intervals of a BED file.
+type bedExpr struct {
+	intervals map[string][][2]int
+}
+
+func (e bedExpr) bind(g *siteGenome) func(pos int) bool {
+	mask := make([]bool, len(g.profile))
+	for _, iv := range e.intervals[g.ref] {
+		fillMask(mask, iv[0], iv[1])
+	}
+	return maskFunc(mask)
+}
+
+func fillMask(mask []bool, start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < end && i < len(mask); i++ {
+		mask[i] = true
+	}
+}
+
+func maskFunc(mask []bool) func(pos int) bool {
+	return func(pos int) bool { return pos >= 0 && pos < len(mask) && mask[pos] }
+}
+
+// siteClasses maps names to site classes.
+var siteClasses = map[string]siteExpr{
+	"noncoding":     typeExpr{posType: profiling.NonCoding},
+	"intergenic":    typeExpr{posType: profiling.NonCoding},
+	"coding":        typeExpr{posType: profiling.Coding},
+	"first":         typeExpr{posType: profiling.FirstPos},
+	"second":        typeExpr{posType: profiling.SecondPos},
+	"third":         typeExpr{posType: profiling.ThirdPos},
+	"fourfold":      typeExpr{posType: profiling.FourFold},
+	"nonsynonymous": orExpr{typeExpr{posType: profiling.FirstPos}, typeExpr{posType: profiling.SecondPos}},
+}
+
+func tokenizeSelector(s string) (tokens []string) {
+	word := ""
+	for _, r := range s {
+		switch r {
+		case '&', '|', '!', '(', ')', ' ', '\t':
+			if word != "" {
+				tokens = append(tokens, word)
+				word = ""
+			}
+			if r != ' ' && r != '\t' {
+				tokens = append(tokens, string(r))
+			}
+		default:
+			word += string(r)
+		}
+	}
+	if word != "" {
+		tokens = append(tokens, word)
+	}
+	return
+}
+
+// selectorParser is a recursive descent parser of site selectors,
+// where ! binds tighter than &, and & binds tighter than |.
+type selectorParser struct {
+	tokens []string
+	i      int
+}
+
+func (p *selectorParser) peek() string {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i]
+	}
+	return ""
+}
+
+func (p *selectorParser) next() string {
+	t := p.peek()
+	p.i++
+	return t
+}
+
+func (p *selectorParser) parseExpr() (siteExpr, error) {
+	e, err := p.parseTerm()
+	for err == nil && p.peek() == "|" {
+		p.next()
+		var b siteExpr
+		b, err = p.parseTerm()
+		e = orExpr{e, b}
+	}
+	return e, err
+}
+
+func (p *selectorParser) parseTerm() (siteExpr, error) {
+	e, err := p.parseFactor()
+	for err == nil && p.peek() == "&" {
+		p.next()
+		var b siteExpr
+		b, err = p.parseFactor()
+		e = andExpr{e, b}
+	}
+	return e, err
+}
+
+func (p *selectorParser) parseFactor() (siteExpr, error) {
+	t := p.next()
+	switch t {
+	case "!":
+		e, err := p.parseFactor()
+		return notExpr{e}, err
+	case "(":
+		e, err := p.parseExpr()
+		if err == nil && p.next() != ")" {
+			err = fmt.Errorf("missing )")
+		}
+		return e, err
+	case "", "&", "|", ")":
+		return nil, fmt.Errorf("unexpected end or %q", t)
+	}
+
+	// functions of files.
+	if t == "gene_set" || t == "bed" {
+		if p.next() != "(" {
+			return nil, fmt.Errorf("%s requires a file", t)
+		}
+		filename := p.next()
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		if t == "gene_set" {
+			return geneSetExpr{ids: readGeneSet(filename)}, nil
+		}
+		return bedExpr{intervals: readBed(filename)}, nil
+	}
+
+	if strings.HasSuffix(t, ".bed") {
+		return bedExpr{intervals: readBed(t)}, nil
+	}
+
+	if e, found := siteClasses[t]; found {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown site class %s", t)
+}
+
+// readGeneSet reads gene IDs, one per line.
+func readGeneSet(filename string) map[string]bool {
+	f := openFile(filename)
+	defer f.Close()
+
+	ids := make(map[string]bool)
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadString('\n')
+		if id := strings.TrimSpace(line); id != "" && !strings.HasPrefix(id, "#") {
+			ids[id] = true
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Fatalln(err)
+			}
+			break
+		}
+	}
+	return ids
+}
+
+// readBed reads intervals of a BED file, keyed by references.
+func readBed(filename string) map[string][][2]int {
+	f := openFile(filename)
+	defer f.Close()
+
+	intervals := make(map[string][][2]int)
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadString('\n')
+		terms := strings.Fields(line)
+		if len(terms) >= 3 && !strings.HasPrefix(terms[0], "#") && terms[0] != "track" && terms[0] != "browser" {
+			start, err1 := strconv.Atoi(terms[1])
+			end, err2 := strconv.Atoi(terms[2])
+			if err1 != nil || err2 != nil {
+				log.Fatalf("Can not parse BED line: %s\n", line)
+			}
+			intervals[terms[0]] = append(intervals[terms[0]], [2]int{start, end})
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Fatalln(err)
+			}
+			break
+		}
+	}
+	return intervals
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestParseSiteExpr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bedFile := filepath.Join(dir, "mask.bed")
+	if err := ioutil.WriteFile(bedFile, []byte("track name=mask\nchr1\t2\t4\nchr2\t0\t6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := []profiling.Pos{
+		{Type: profiling.NonCoding},
+		{Type: profiling.FirstPos},
+		{Type: profiling.SecondPos},
+		{Type: profiling.ThirdPos},
+		{Type: profiling.FourFold},
+		{Type: profiling.NonCoding},
+	}
+
+	tests := []struct {
+		expr     string
+		selected []int // selected positions, nil if the expression is invalid.
+	}{
+		{"coding", []int{1, 2, 3, 4}},
+		{"noncoding", []int{0, 5}},
+		{"intergenic", []int{0, 5}},
+		{"third", []int{3, 4}},
+		{"fourfold", []int{4}},
+		{"nonsynonymous", []int{1, 2}},
+		{"!coding", []int{0, 5}},
+		{"!!fourfold", []int{4}},
+		{"first | second", []int{1, 2}},
+		{"coding & !fourfold", []int{1, 2, 3}},
+		{"!first & coding | fourfold", []int{2, 3, 4}},
+		{"!(first | fourfold) & coding", []int{2, 3}},
+		{"((third))", []int{3, 4}},
+		{bedFile, []int{2, 3}},
+		{"coding & !bed(" + bedFile + ")", []int{1, 4}},
+		{"", nil},
+		{"coding &", nil},
+		{"(coding", nil},
+		{"coding)", nil},
+		{"coding fourfold", nil},
+		{"exons", nil},
+		{"bed " + bedFile, nil},
+	}
+	for _, test := range tests {
+		e, err := parseSiteExpr(test.expr)
+		if test.selected == nil {
+			if err == nil {
+				t.Errorf("%q: expect an error\n", test.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v\n", test.expr, err)
+			continue
+		}
+
+		selectSite := e.bind(&siteGenome{ref: "chr1", profile: profile})
+		var selected []int
+		for pos := -1; pos <= len(profile); pos++ {
+			if selectSite(pos) {
+				selected = append(selected, pos)
+			}
+		}
+		if !reflect.DeepEqual(selected, test.selected) {
+			t.Errorf("%q: expect %v, got %v\n", test.expr, test.selected, selected)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/mingzhi/biogo/feat/gff"
 	"github.com/mingzhi/biogo/seq"
 	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/pileup/calc"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func openFile(filename string) *os.File {
@@ -27,22 +32,32 @@ func createFile(filename string) *os.File {
 	return w
 }
 
-// readGenome read the genome file
-// and return a genomic sequence.
-func readGenome(filename string) []byte {
-	f, err := os.Open(filename)
-	if err != nil {
-		panic(err)
-	}
+// readGenomes reads a multi-record fasta file
+// and returns sequences keyed by their accessions.
+func readGenomes(filename string) map[string][]byte {
+	f := openFile(filename)
 	defer f.Close()
 
 	rd := seq.NewFastaReader(f)
+	rd.DeflineParser = func(s string) string { return strings.Split(strings.TrimSpace(s), " ")[0] }
 	ss, err := rd.ReadAll()
 	if err != nil {
 		panic(err)
 	}
 
-	return ss[0].Seq
+	m := make(map[string][]byte)
+	for _, s := range ss {
+		m[s.Id] = bytes.ToUpper(s.Seq)
+	}
+
+	return m
+}
+
+// refOutFile returns the output file of a reference,
+// which inserts the reference name before the extension.
+func refOutFile(filename, ref string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "_" + ref + ext
 }
 
 func readGff(filename string) []*gff.Record {
@@ -65,6 +80,18 @@ func readGff(filename string) []*gff.Record {
 	return records
 }
 
+// gffID returns the ID attribute of a GFF record,
+// or its location if it has no ID.
+func gffID(r *gff.Record) string {
+	for _, attr := range strings.Split(r.Attributes, ";") {
+		kv := strings.SplitN(strings.TrimSpace(attr), "=", 2)
+		if len(kv) == 2 && kv[0] == "ID" {
+			return kv[1]
+		}
+	}
+	return fmt.Sprintf("%s:%d-%d", r.SeqName, r.Start, r.End)
+}
+
 func readPi(filename string) chan Pi {
 	c := make(chan Pi)
 	go func() {
@@ -147,3 +174,47 @@ func isATGC(b byte) bool {
 
 	return false
 }
+
+// newLagBins returns bins of lags:
+// a bin per lag less than maxl (linear),
+// log-spaced bins after linear lags (log),
+// or bins read from a file.
+func newLagBins(binning string, maxl, linear, perDecade int) *calc.Bins {
+	switch binning {
+	case "", "linear":
+		return calc.NewLinearBins(maxl)
+	case "log":
+		if perDecade <= 0 || linear < 0 {
+			log.Fatalln("Log bins require positive bins per decade and non-negative linear lags.")
+		}
+		return calc.NewLogBins(linear, maxl, perDecade)
+	}
+
+	f := openFile(binning)
+	defer f.Close()
+	bins, err := calc.ReadBins(f)
+	if err != nil {
+		log.Fatalf("Can not read bins from %s: %v\n", binning, err)
+	}
+	return bins
+}
+
+// splitNames splits a comma-separated list of names.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func hasString(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
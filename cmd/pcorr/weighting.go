@@ -0,0 +1,38 @@
+package main
+
+import "log"
+
+// Weightings of chuncks (or genes) in means over chuncks:
+// equal weights, weights by the number of site (or read) pairs,
+// or weights by the inverse of sampling variances.
+// Weighting by pairs also weights site pairs in a chunck
+// by their numbers of compared read pairs.
+const (
+	noWeighting              = "none"
+	pairsWeighting           = "pairs"
+	inverseVarianceWeighting = "inverse-variance"
+)
+
+func checkWeighting(weighting string) {
+	switch weighting {
+	case noWeighting, pairsWeighting, inverseVarianceWeighting:
+	default:
+		log.Fatalf("Can not recognize the weighting: %s\n", weighting)
+	}
+}
+
+// weightOf returns the weight of an estimate from n data points
+// with the sampling variance v.
+// An estimate without variance has weight 0 by the inverse variance.
+func weightOf(weighting string, n int, v float64) float64 {
+	switch weighting {
+	case pairsWeighting:
+		return float64(n)
+	case inverseVarianceWeighting:
+		if v > 0 {
+			return 1 / v
+		}
+		return 0
+	}
+	return 1
+}
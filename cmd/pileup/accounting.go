@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// stageCounts counts records in and out of a stage of filters,
+// per reference.
+type stageCounts struct {
+	Stage string                `json:"stage"`
+	Refs  map[string]*refCounts `json:"refs"`
+
+	refs []string // references in order of appearance.
+}
+
+type refCounts struct {
+	In  int `json:"in"`
+	Out int `json:"out"`
+}
+
+func newStageCounts(stage string) *stageCounts {
+	return &stageCounts{Stage: stage, Refs: make(map[string]*refCounts)}
+}
+
+func (s *stageCounts) ref(name string) *refCounts {
+	c, found := s.Refs[name]
+	if !found {
+		c = &refCounts{}
+		s.Refs[name] = c
+		s.refs = append(s.refs, name)
+	}
+	return c
+}
+
+// AddIn counts a record into the stage.
+func (s *stageCounts) AddIn(ref string) {
+	s.ref(ref).In++
+}
+
+// AddOut counts a record out of the stage.
+func (s *stageCounts) AddOut(ref string) {
+	s.ref(ref).Out++
+}
+
+// Total returns counts of all references.
+func (s *stageCounts) Total() refCounts {
+	var total refCounts
+	for _, c := range s.Refs {
+		total.In += c.In
+		total.Out += c.Out
+	}
+	return total
+}
+
+// filterReport contains counts of each stage of filters.
+type filterReport struct {
+	Stages []*stageCounts `json:"stages"`
+}
+
+// writeFilterReport writes counts in JSON,
+// or in TSV of stage, reference, in and out,
+// followed by totals of each stage, of which the reference is "total".
+func writeFilterReport(filename, format string, report *filterReport) {
+	w := createFile(filename)
+	defer w.Close()
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false) // keep comparisons of stages.
+		enc.SetIndent("", "  ")
+		raiseError(enc.Encode(report))
+		return
+	}
+
+	w.WriteString("stage\treference\tin\tout\n")
+	for _, s := range report.Stages {
+		for _, ref := range s.refs {
+			c := s.Refs[ref]
+			w.WriteString(fmt.Sprintf("%s\t%s\t%d\t%d\n", s.Stage, ref, c.In, c.Out))
+		}
+		total := s.Total()
+		w.WriteString(fmt.Sprintf("%s\ttotal\t%d\t%d\n", s.Stage, total.In, total.Out))
+	}
+}
+
+// divergenceHist is a histogram of per-read divergence,
+// in bins of width 1/len(Counts) from 0 to 1.
+type divergenceHist struct {
+	Counts []int
+}
+
+func newDivergenceHist(numBins int) *divergenceHist {
+	return &divergenceHist{Counts: make([]int, numBins)}
+}
+
+// Add adds a read of diff differences in length bases.
+func (h *divergenceHist) Add(diff, length int) {
+	if length <= 0 {
+		return
+	}
+	n := len(h.Counts)
+	i := int(math.Floor(float64(diff) / float64(length) * float64(n)))
+	if i >= n {
+		i = n - 1
+	}
+	h.Counts[i]++
+}
+
+// Write writes the start and end of each bin and its number of reads.
+func (h *divergenceHist) Write(filename string) {
+	w := createFile(filename)
+	defer w.Close()
+
+	n := len(h.Counts)
+	w.WriteString("start\tend\treads\n")
+	for i, c := range h.Counts {
+		w.WriteString(fmt.Sprintf("%g\t%g\t%d\n", float64(i)/float64(n), float64(i+1)/float64(n), c))
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Default --mapQ and --max_dist of the chain of filters used without --filters.
+const (
+	defaultMapQ        = 30
+	defaultMaxDistance = 0.05
+)
+
+// defaultFilters returns the chain of filters used without --filters.
+func defaultFilters(mapQ int, maxDistance float64) string {
+	return fmt.Sprintf("mapq>=%d,proper_pair,proper_map,diversity<=%g", mapQ, maxDistance)
+}
+
+// filterConfig is a chain of filters in a YAML file, e.g.
+//
+//	filters:
+//	  - mapq>=30
+//	  - proper_pair
+//	  - max_softclip<=0.1
+//	  - diversity<=0.05
+//	unpaired: false
+type filterConfig struct {
+	Filters  []string `yaml:"filters"`
+	Unpaired bool     `yaml:"unpaired"`
+}
+
+func readFilterConfig(filename string) *filterConfig {
+	data, err := ioutil.ReadFile(filename)
+	raiseError(err)
+	var config filterConfig
+	err = yaml.Unmarshal(data, &config)
+	raiseError(err)
+	return &config
+}
+
+// parseFilters parses filters, each of which is a name,
+// or a name, a comparison and a value, e.g. "mapq>=30":
+//
+//	mapq>=N          min mapping quality.
+//	proper_pair      properly paired primary alignments of mapped mates.
+//	proper_map       no insertions, and at most 10% of bases unmatched.
+//	duplicate        no PCR or optical duplicates.
+//	nm<=N            at most N edits (NM tag).
+//	insert_size>=N   min insert size; insert_size<=N for the max,
+//	                 and insert_size=N for an exact size.
+//	min_length>=N    min read length.
+//	max_softclip<=F  at most a fraction F of bases soft-clipped.
+//	diversity<=F     max divergence of read pairs (or reads) from the reference,
+//	                 which pairs mates, so it must be the last filter.
+//
+// A bound given by "=" is the same as the comparison of its filter,
+// e.g. "mapq=30" is "mapq>=30".
+//
+// It returns read filters in order and their names,
+// and the max divergence of the diversity filter,
+// which is negative if reads are not filtered by diversity.
+func parseFilters(specs []string) (filters []filter, names []string, maxDiversity float64, err error) {
+	maxDiversity = -1
+	for i, spec := range specs {
+		name, op, value := splitFilter(spec)
+		var f filter
+		switch name {
+		case "proper_pair", "proper_map", "duplicate":
+			if op != "" {
+				return nil, nil, 0, fmt.Errorf("filter %s takes no value: %s", name, spec)
+			}
+			switch name {
+			case "proper_pair":
+				f = &ProperPairFilter{}
+			case "proper_map":
+				f = &ProperMapFilter{}
+			case "duplicate":
+				f = &DuplicateFilter{}
+			}
+		case "mapq", "min_length":
+			var n int
+			if n, err = parseBound(spec, op, value, ">="); err == nil {
+				if name == "mapq" {
+					f = &MapQFilter{Cutoff: n}
+				} else {
+					f = &MinLengthFilter{Min: n}
+				}
+			}
+		case "nm":
+			var n int
+			if n, err = parseBound(spec, op, value, "<="); err == nil {
+				f = &NMFilter{Max: n}
+			}
+		case "insert_size":
+			var n int
+			switch op {
+			case ">=":
+				n, err = parseBound(spec, op, value, op)
+				f = &InsertSizeFilter{Min: n}
+			case "<=":
+				n, err = parseBound(spec, op, value, op)
+				f = &InsertSizeFilter{Max: n}
+			case "=":
+				n, err = parseBound(spec, op, value, op)
+				f = &InsertSizeFilter{Min: n, Max: n}
+			default:
+				err = fmt.Errorf("filter %s expects >=, <= or =", spec)
+			}
+		case "max_softclip", "diversity":
+			var v float64
+			if v, err = parseFraction(spec, op, value); err == nil {
+				if name == "max_softclip" {
+					f = &SoftClipFilter{MaxFraction: v}
+				} else if i < len(specs)-1 {
+					err = fmt.Errorf("filter %s must be the last", spec)
+				} else {
+					maxDiversity = v
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown filter: %s", spec)
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if f != nil {
+			filters = append(filters, f)
+			names = append(names, strings.Replace(spec, " ", "", -1))
+		}
+	}
+	return
+}
+
+// splitFilters splits comma-separated filters.
+func splitFilters(s string) (specs []string) {
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return
+}
+
+// splitFilter splits a filter into its name, comparison and value.
+func splitFilter(spec string) (name, op, value string) {
+	spec = strings.TrimSpace(spec)
+	i := strings.IndexAny(spec, "<>=")
+	if i < 0 {
+		return spec, "", ""
+	}
+	j := i + 1
+	if j < len(spec) && spec[j] == '=' {
+		j++
+	}
+	return strings.TrimSpace(spec[:i]), spec[i:j], strings.TrimSpace(spec[j:])
+}
+
+// parseBound parses an integer bound of the comparison op, or "=".
+func parseBound(spec, op, value, want string) (int, error) {
+	if op != want && op != "=" {
+		return 0, fmt.Errorf("filter %s expects %s", spec, want)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid filter %s: %v", spec, err)
+	}
+	return n, nil
+}
+
+// parseFraction parses an upper bound of a fraction.
+func parseFraction(spec, op, value string) (float64, error) {
+	if op != "<=" && op != "=" {
+		return 0, fmt.Errorf("filter %s expects <=", spec)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 || v > 1 {
+		return 0, fmt.Errorf("invalid fraction in filter %s", spec)
+	}
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		spec   string
+		filter filter // nil if the filter is invalid.
+	}{
+		{"mapq>=30", &MapQFilter{Cutoff: 30}},
+		{"mapq=30", &MapQFilter{Cutoff: 30}},
+		{"nm<=5", &NMFilter{Max: 5}},
+		{"nm=5", &NMFilter{Max: 5}},
+		{"min_length = 50", &MinLengthFilter{Min: 50}},
+		{"insert_size>=100", &InsertSizeFilter{Min: 100}},
+		{"insert_size<=500", &InsertSizeFilter{Max: 500}},
+		{"insert_size=300", &InsertSizeFilter{Min: 300, Max: 300}},
+		{"max_softclip=0.1", &SoftClipFilter{MaxFraction: 0.1}},
+		{"max_softclip<=0.1", &SoftClipFilter{MaxFraction: 0.1}},
+		{"mapq<=30", nil},
+		{"nm>=5", nil},
+		{"insert_size>100", nil},
+		{"insert_size", nil},
+		{"max_softclip>=0.1", nil},
+		{"max_softclip<=2", nil},
+		{"proper_pair=1", nil},
+		{"mapq>=x", nil},
+	}
+	for _, test := range tests {
+		filters, _, _, err := parseFilters([]string{test.spec})
+		if test.filter == nil {
+			if err == nil {
+				t.Errorf("%q: expect an error\n", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v\n", test.spec, err)
+			continue
+		}
+		if len(filters) != 1 || !reflect.DeepEqual(filters[0], test.filter) {
+			t.Errorf("%q: expect %+v, got %+v\n", test.spec, test.filter, filters)
+		}
+	}
+
+	if _, _, _, err := parseFilters([]string{"diversity<=0.05", "mapq>=30"}); err == nil {
+		t.Error("expect an error of diversity before other filters")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/bmatsuo/lmdb-go/lmdb"
@@ -14,13 +15,20 @@ import (
 )
 
 type cmdFilter struct {
-	bamFile     string
-	outFile     string
-	featureDB   string
-	maxDistance float64
-	mapQ        int
+	bamFile      string
+	outFile      string
+	featureDB    string
+	maxDistance  float64  // negative if not given.
+	mapQ         int      // negative if not given.
+	filterSpecs  []string // chain of filters, see parseFilters.
+	unpaired     bool     // filter reads without pairing mates.
+	reportFormat string   // format of counts of filters: tsv, json or none.
+	histBins     int      // bins of the divergence histogram, 0 for none.
+	pairing      string   // pairing of mates: auto, memory or lmdb.
+	pairWindow   int      // max reads waiting for mates in memory.
 
 	filters []filter
+	report  *filterReport
 }
 
 type filter interface {
@@ -28,27 +36,85 @@ type filter interface {
 }
 
 func (c *cmdFilter) run() {
-	c.filters = append(c.filters, &MapQFilter{Cutoff: c.mapQ})
-	c.filters = append(c.filters, &ProperPairFilter{})
-	c.filters = append(c.filters, &ProperMapFilter{})
+	if len(c.filterSpecs) == 0 {
+		if c.mapQ < 0 {
+			c.mapQ = defaultMapQ
+		}
+		if c.maxDistance < 0 {
+			c.maxDistance = defaultMaxDistance
+		}
+		c.filterSpecs = splitFilters(defaultFilters(c.mapQ, c.maxDistance))
+	} else if c.mapQ >= 0 || c.maxDistance >= 0 {
+		log.Fatalln("--mapQ and --max_dist are not used with a chain of filters; use mapq>= and diversity<= filters instead.")
+	}
+	filters, names, maxDiversity, err := parseFilters(c.filterSpecs)
+	raiseError(err)
+	c.filters = filters
+	c.report = &filterReport{}
+	for _, name := range names {
+		c.report.Stages = append(c.report.Stages, newStageCounts(name))
+	}
+	if c.unpaired {
+		for _, f := range c.filters {
+			if _, ok := f.(*ProperPairFilter); ok {
+				log.Fatalln("proper_pair filter requires paired reads.")
+			}
+		}
+	}
 
 	header := c.readBamHeader()
 
 	ch := c.readBamFile()
-	filteredChan1 := c.filter(ch)
+	filteredChan := c.filter(ch)
+
+	var hist *divergenceHist
+	if maxDiversity >= 0 {
+		diverFiler := DiversityFilter{
+			Cutoff:   maxDiversity,
+			Unpaired: c.unpaired,
+			Counts:   newStageCounts(fmt.Sprintf("diversity<=%g", maxDiversity)),
+		}
+		if c.histBins > 0 {
+			hist = newDivergenceHist(c.histBins)
+			diverFiler.Hist = hist
+		}
+		c.report.Stages = append(c.report.Stages, diverFiler.Counts)
+		if !c.unpaired {
+			diverFiler.SortOrder = c.pairingOrder(header)
+			diverFiler.Window = c.pairWindow
+		}
+		diverFiler.OpenFeatureDB(c.featureDB)
+		defer diverFiler.Close()
+		filteredChan = diverFiler.FilterAll(filteredChan)
+	}
 
-	diverFiler := DiversityFilter{
-		Cutoff: c.maxDistance,
+	c.write(header, filteredChan)
+
+	// Write counts of filters and the histogram next to the output.
+	if c.reportFormat != "none" {
+		writeFilterReport(c.outFile+".filter."+c.reportFormat, c.reportFormat, c.report)
 	}
-	diverFiler.OpenFeatureDB(c.featureDB)
-	tempDir, err := ioutil.TempDir("temp", "filter")
-	raiseError(err)
-	defer os.RemoveAll(tempDir)
-	diverFiler.CreateTempDB(tempDir)
-	defer diverFiler.Close()
-	filteredChan2 := diverFiler.FilterAll(filteredChan1)
+	if hist != nil {
+		hist.Write(c.outFile + ".divergence.tsv")
+	}
+}
 
-	c.write(header, filteredChan2)
+// pairingOrder returns the sort order of reads for pairing mates in memory,
+// or sam.UnknownOrder for pairing them in a temporary LMDB.
+func (c *cmdFilter) pairingOrder(header *sam.Header) sam.SortOrder {
+	sorted := header.SortOrder == sam.QueryName || header.SortOrder == sam.Coordinate
+	switch c.pairing {
+	case "lmdb":
+		return sam.UnknownOrder
+	case "memory":
+		if !sorted {
+			log.Fatalln("pairing in memory requires name- or coordinate-sorted reads.")
+		}
+	}
+	if !sorted {
+		return sam.UnknownOrder
+	}
+	return header.SortOrder
 }
 
 func (c *cmdFilter) readBamHeader() *sam.Header {
@@ -92,11 +158,15 @@ func (c *cmdFilter) filter(ch chan *sam.Record) chan *sam.Record {
 		defer close(filteredChan)
 		for r := range ch {
 			good := true
-			for _, f := range c.filters {
+			ref := r.Ref.Name()
+			for i, f := range c.filters {
+				stage := c.report.Stages[i]
+				stage.AddIn(ref)
 				good = f.Filter(r)
 				if !good {
 					break
 				}
+				stage.AddOut(ref)
 			}
 
 			if good {
@@ -165,17 +235,43 @@ func (p *ProperMapFilter) Filter(r *sam.Record) bool {
 
 type DiversityFilter struct {
 	Cutoff    float64
+	Unpaired  bool            // filter reads one by one, without pairing mates.
+	Counts    *stageCounts    // counts of reads in and out.
+	Hist      *divergenceHist // histogram of per-read divergence, if not nil.
+	SortOrder sam.SortOrder   // order of reads for pairing mates in memory, see FilterAll.
+	Window    int             // max reads waiting for mates in memory.
 	db        *lmdb.Env
 	featureDB *lmdb.Env
+	tempDir   string // temporary LMDB of reads waiting for mates, created when needed.
 
 	sizeDB int64
+
+	// genome of the accession, loaded only when a read has neither NM nor MD tags.
+	acc     string
+	genome  []byte
+	missing map[string]bool // accessions not in the feature DB.
 }
 
 func (d *DiversityFilter) Close() {
-	d.db.Close()
+	if d.db != nil {
+		d.db.Close()
+		os.RemoveAll(d.tempDir)
+	}
 	d.featureDB.Close()
 }
 
+// openTempDB creates the temp db in a new directory under ./temp,
+// which is removed by Close.
+func (d *DiversityFilter) openTempDB() {
+	if d.db != nil {
+		return
+	}
+	var err error
+	d.tempDir, err = ioutil.TempDir("temp", "filter")
+	raiseError(err)
+	d.CreateTempDB(d.tempDir)
+}
+
 // create temp db
 func (d *DiversityFilter) CreateTempDB(path string) {
 	var numDB int = 10
@@ -204,7 +300,7 @@ func (d *DiversityFilter) OpenFeatureDB(path string) {
 	raiseError(err)
 }
 
-func (d *DiversityFilter) filter(buf []*sam.Record, acc string, genome []byte) (out []*sam.Record, acc1 string, genome1 []byte) {
+func (d *DiversityFilter) filter(buf []*sam.Record) (out []*sam.Record) {
 	fn := func(txn *lmdb.Txn) error {
 		dbi, err := txn.OpenDBI("read", 0)
 		if err != nil {
@@ -231,26 +327,9 @@ func (d *DiversityFilter) filter(buf []*sam.Record, acc string, genome []byte) (
 				var mate *sam.Record = &sam.Record{}
 				err := mate.UnmarshalText(val)
 				raiseError(err)
-				if r.Ref.Name() == mate.Ref.Name() {
-					if acc != r.Ref.Name() {
-						genome, err = d.findGenome(r, d.featureDB, "fna")
-						raiseError(err)
-						acc = r.Ref.Name()
-						if *debug {
-							log.Println(acc)
-						}
-					}
-
-					diff1, len1 := d.Diff(r, genome)
-					diff2, len2 := d.Diff(mate, genome)
-					if len1 > 0 && len2 > 0 && float64(diff1+diff2)/float64(len1+len2) <= d.Cutoff {
-						out = append(out, r)
-						out = append(out, mate)
-					} else {
-						if *debug {
-							log.Printf("%d, %d, %d, %d\n", diff1, diff2, len1, len2)
-						}
-					}
+				if r.Ref.Name() == mate.Ref.Name() && d.keepPair(r, mate) {
+					out = append(out, r)
+					out = append(out, mate)
 				}
 
 				txn.Del(dbi, key, val)
@@ -259,6 +338,14 @@ func (d *DiversityFilter) filter(buf []*sam.Record, acc string, genome []byte) (
 		}
 		return nil
 	}
+	d.update(fn)
+
+	return
+}
+
+// update updates the temp db, growing it when it is full.
+func (d *DiversityFilter) update(fn func(txn *lmdb.Txn) error) {
+	d.openTempDB()
 retry:
 	err := d.db.Update(fn)
 	if lmdb.IsMapFull(err) {
@@ -268,10 +355,24 @@ retry:
 		goto retry
 	}
 	raiseError(err)
+}
 
-	genome1 = genome
-	acc1 = acc
-	return
+// keepPair returns true if the divergence of a read pair
+// is at most the cutoff.
+func (d *DiversityFilter) keepPair(r, mate *sam.Record) bool {
+	diff1, len1 := d.Diff(r)
+	diff2, len2 := d.Diff(mate)
+	if d.Hist != nil {
+		d.Hist.Add(diff1, len1)
+		d.Hist.Add(diff2, len2)
+	}
+	if len1 > 0 && len2 > 0 && float64(diff1+diff2)/float64(len1+len2) <= d.Cutoff {
+		return true
+	}
+	if *debug {
+		log.Printf("%d, %d, %d, %d\n", diff1, diff2, len1, len2)
+	}
+	return false
 }
 
 func (d *DiversityFilter) findGenome(r *sam.Record, env *lmdb.Env, dbname string) (val []byte, err error) {
@@ -289,30 +390,40 @@ func (d *DiversityFilter) findGenome(r *sam.Record, env *lmdb.Env, dbname string
 	return
 }
 
+// FilterAll keeps read pairs of divergence at most the cutoff.
+// Mates are paired in memory if reads are sorted by name or coordinate,
+// or else in the temp db.
 func (d *DiversityFilter) FilterAll(ch chan *sam.Record) chan *sam.Record {
+	if d.Unpaired {
+		return d.filterUnpaired(ch)
+	}
+	if d.SortOrder == sam.QueryName || d.SortOrder == sam.Coordinate {
+		return d.filterSorted(ch)
+	}
+
 	filteredChan := make(chan *sam.Record)
 
 	go func() {
 		defer close(filteredChan)
 		buf := make([]*sam.Record, 10000)
-		var out []*sam.Record
-		var acc string
-		var genome []byte
 		k := 0
 		for r := range ch {
 			if k >= len(buf) {
-				out, acc, genome = d.filter(buf, acc, genome)
+				out := d.filter(buf)
 				for _, r1 := range out {
+					d.Counts.AddOut(r1.Ref.Name())
 					filteredChan <- r1
 				}
 				k = 0
 			}
+			d.Counts.AddIn(r.Ref.Name())
 			buf[k] = r
 			k++
 		}
 
-		out, _, _ = d.filter(buf, acc, genome)
+		out := d.filter(buf[:k])
 		for _, r1 := range out {
+			d.Counts.AddOut(r1.Ref.Name())
 			filteredChan <- r1
 		}
 	}()
@@ -320,7 +431,42 @@ func (d *DiversityFilter) FilterAll(ch chan *sam.Record) chan *sam.Record {
 	return filteredChan
 }
 
-func (d *DiversityFilter) Diff(r *sam.Record, genome []byte) (diff, length int) {
+// filterUnpaired keeps reads of divergence at most the cutoff,
+// without pairing mates.
+func (d *DiversityFilter) filterUnpaired(ch chan *sam.Record) chan *sam.Record {
+	filteredChan := make(chan *sam.Record)
+
+	go func() {
+		defer close(filteredChan)
+		for r := range ch {
+			ref := r.Ref.Name()
+			d.Counts.AddIn(ref)
+			diff, length := d.Diff(r)
+			if d.Hist != nil {
+				d.Hist.Add(diff, length)
+			}
+			if length > 0 && float64(diff)/float64(length) <= d.Cutoff {
+				d.Counts.AddOut(ref)
+				filteredChan <- r
+			}
+		}
+	}()
+
+	return filteredChan
+}
+
+// Diff returns the number of differences of a read from the reference,
+// which are mismatched, inserted and deleted bases,
+// and the length of the alignment, of matched, inserted and deleted bases.
+// Differences are counted from the NM tag, or the MD tag,
+// or else by comparing with the reference genome.
+// The length is 0 if the read can not be compared.
+func (d *DiversityFilter) Diff(r *sam.Record) (diff, length int) {
+	if diff, length, ok := tagDiff(r); ok {
+		return diff, length
+	}
+
+	genome := d.genomeOf(r)
 	start := r.Start()
 	end := r.End()
 	if start < 0 || end > len(genome) {
@@ -335,12 +481,98 @@ func (d *DiversityFilter) Diff(r *sam.Record, genome []byte) (diff, length int)
 	refSeq := genome[start:end]
 	diff = 0
 	read := map2Ref(r)
-	length = len(read)
-	for i := 0; i < length; i++ {
-		if read[i] != refSeq[i] {
+	for i := 0; i < len(read); i++ {
+		if read[i] != skippedBase && read[i] != refSeq[i] {
 			diff++
 		}
 	}
+
+	// map2Ref skips inserted bases.
+	length, inserted := alignLength(r)
+	diff += inserted
+	return
+}
+
+// genomeOf returns the genome of the reference of a read,
+// which is nil if it is not in the feature DB.
+func (d *DiversityFilter) genomeOf(r *sam.Record) []byte {
+	acc := r.Ref.Name()
+	if acc == d.acc {
+		return d.genome
+	}
+	if d.missing[acc] {
+		return nil
+	}
+
+	genome, err := d.findGenome(r, d.featureDB, "fna")
+	if err != nil {
+		if !lmdb.IsNotFound(err) {
+			raiseError(err)
+		}
+		log.Printf("Can not find the genome of %s, skip its reads without NM or MD tags.\n", acc)
+		if d.missing == nil {
+			d.missing = make(map[string]bool)
+		}
+		d.missing[acc] = true
+		return nil
+	}
+	if *debug {
+		log.Println(acc)
+	}
+	d.acc, d.genome = acc, genome
+	return genome
+}
+
+// tagDiff returns differences and the alignment length of a read
+// from its NM tag, or its MD tag and CIGAR,
+// and false if the read has neither tags.
+func tagDiff(r *sam.Record) (diff, length int, ok bool) {
+	length, inserted := alignLength(r)
+	if nm, found := auxInt(r.AuxFields.Get(sam.NewTag("NM"))); found {
+		return nm, length, true
+	}
+	if aux := r.AuxFields.Get(sam.NewTag("MD")); aux != nil {
+		if md, isString := aux.Value().(string); isString {
+			mismatches, deleted := mdDiff(md)
+			return mismatches + deleted + inserted, length, true
+		}
+	}
+	return 0, 0, false
+}
+
+// alignLength returns the length of the alignment of a read,
+// of matched, inserted and deleted bases,
+// and the number of inserted bases.
+func alignLength(r *sam.Record) (length, inserted int) {
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual, sam.CigarDeletion:
+			length += co.Len()
+		case sam.CigarInsertion:
+			length += co.Len()
+			inserted += co.Len()
+		}
+	}
+	return
+}
+
+// mdDiff returns the numbers of mismatched and deleted bases in a MD tag,
+// e.g. "10A5^AC6" of a mismatch and two deleted bases.
+func mdDiff(md string) (mismatches, deleted int) {
+	inDeletion := false
+	for i := 0; i < len(md); i++ {
+		c := md[i]
+		switch {
+		case c >= '0' && c <= '9':
+			inDeletion = false
+		case c == '^':
+			inDeletion = true
+		case inDeletion:
+			deleted++
+		default:
+			mismatches++
+		}
+	}
 	return
 }
 
@@ -371,6 +603,10 @@ func readRefSeq(fnaFile string) map[string][]byte {
 
 // Obtain the sequence of a read mapping to the reference genome.
 // Return the mapped sequence.
+// skippedBase marks reference bases skipped by a read (CIGAR N),
+// which are neither differences nor aligned.
+const skippedBase = '.'
+
 func map2Ref(r *sam.Record) []byte {
 	s := []byte{}
 	p := 0                                // position in the read sequence.
@@ -382,10 +618,107 @@ func map2Ref(r *sam.Record) []byte {
 			p += c.Len()
 		case sam.CigarInsertion, sam.CigarSoftClipped, sam.CigarHardClipped:
 			p += c.Len()
-		case sam.CigarDeletion, sam.CigarSkipped:
+		case sam.CigarDeletion:
 			s = append(s, bytes.Repeat([]byte{'*'}, c.Len())...)
+		case sam.CigarSkipped:
+			s = append(s, bytes.Repeat([]byte{skippedBase}, c.Len())...)
 		}
 	}
 
 	return s
 }
+
+// DuplicateFilter removes reads marked as PCR or optical duplicates.
+type DuplicateFilter struct {
+}
+
+func (d *DuplicateFilter) Filter(r *sam.Record) bool {
+	return r.Flags&sam.Duplicate != sam.Duplicate
+}
+
+// NMFilter removes reads of more than Max edits (the NM tag).
+// Reads without the NM tag are kept.
+type NMFilter struct {
+	Max int
+}
+
+func (n *NMFilter) Filter(r *sam.Record) bool {
+	nm, found := auxInt(r.AuxFields.Get(sam.NewTag("NM")))
+	return !found || nm <= n.Max
+}
+
+// InsertSizeFilter removes read pairs of insert sizes
+// less than Min or greater than Max (if positive).
+type InsertSizeFilter struct {
+	Min, Max int
+}
+
+func (s *InsertSizeFilter) Filter(r *sam.Record) bool {
+	size := r.TempLen
+	if size < 0 {
+		size = -size
+	}
+	return size >= s.Min && (s.Max <= 0 || size <= s.Max)
+}
+
+// MinLengthFilter removes reads shorter than Min,
+// excluding hard-clipped bases.
+type MinLengthFilter struct {
+	Min int
+}
+
+func (m *MinLengthFilter) Filter(r *sam.Record) bool {
+	return readLength(r) >= m.Min
+}
+
+// SoftClipFilter removes reads of which
+// more than MaxFraction of bases are soft-clipped.
+type SoftClipFilter struct {
+	MaxFraction float64
+}
+
+func (s *SoftClipFilter) Filter(r *sam.Record) bool {
+	var clipped int
+	for _, co := range r.Cigar {
+		if co.Type() == sam.CigarSoftClipped {
+			clipped += co.Len()
+		}
+	}
+	readLen := readLength(r)
+	return readLen > 0 && float64(clipped) <= s.MaxFraction*float64(readLen)
+}
+
+// readLength returns the length of the read sequence in the CIGAR,
+// excluding hard-clipped bases.
+func readLength(r *sam.Record) (length int) {
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual, sam.CigarInsertion, sam.CigarSoftClipped:
+			length += co.Len()
+		}
+	}
+	return
+}
+
+// auxInt returns the integer value of an optional field,
+// and false if the field is missing or not an integer.
+func auxInt(a sam.Aux) (int, bool) {
+	if a == nil {
+		return 0, false
+	}
+	switch v := a.Value().(type) {
+	case int8:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestMDDiff(t *testing.T) {
+	tests := []struct {
+		md                  string
+		mismatches, deleted int
+	}{
+		{"24", 0, 0},
+		{"10A5^AC6", 1, 2},
+		{"0A0C8", 2, 0},
+		{"5^T0G4", 1, 1},
+	}
+	for _, test := range tests {
+		mismatches, deleted := mdDiff(test.md)
+		if mismatches != test.mismatches || deleted != test.deleted {
+			t.Errorf("%s: expect %d mismatches and %d deleted, got %d and %d\n",
+				test.md, test.mismatches, test.deleted, mismatches, deleted)
+		}
+	}
+}
+
+func TestTagDiff(t *testing.T) {
+	aux := func(tag string, v interface{}) sam.Aux {
+		a, err := sam.NewAux(sam.NewTag(tag), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	m, i, d, n := sam.CigarMatch, sam.CigarInsertion, sam.CigarDeletion, sam.CigarSkipped
+
+	tests := []struct {
+		name         string
+		cigar        sam.Cigar
+		aux          sam.AuxFields
+		diff, length int
+		ok           bool
+	}{
+		{
+			name:  "MD",
+			cigar: sam.Cigar{sam.NewCigarOp(m, 16), sam.NewCigarOp(d, 2), sam.NewCigarOp(m, 6)},
+			aux:   sam.AuxFields{aux("MD", "10A5^AC6")},
+			diff:  3, length: 24, ok: true,
+		},
+		{
+			name:  "NM over MD",
+			cigar: sam.Cigar{sam.NewCigarOp(m, 16), sam.NewCigarOp(d, 2), sam.NewCigarOp(m, 6)},
+			aux:   sam.AuxFields{aux("MD", "10A5^AC6"), aux("NM", 1)},
+			diff:  1, length: 24, ok: true,
+		},
+		{
+			name:  "MD and insertions",
+			cigar: sam.Cigar{sam.NewCigarOp(m, 5), sam.NewCigarOp(i, 2), sam.NewCigarOp(m, 5)},
+			aux:   sam.AuxFields{aux("MD", "10")},
+			diff:  2, length: 12, ok: true,
+		},
+		{
+			name:  "MD and skipped bases",
+			cigar: sam.Cigar{sam.NewCigarOp(m, 3), sam.NewCigarOp(n, 4), sam.NewCigarOp(m, 2)},
+			aux:   sam.AuxFields{aux("MD", "4A0")},
+			diff:  1, length: 5, ok: true,
+		},
+		{
+			name:  "no tags",
+			cigar: sam.Cigar{sam.NewCigarOp(m, 10)},
+		},
+	}
+	for _, test := range tests {
+		r := &sam.Record{Cigar: test.cigar, AuxFields: test.aux}
+		diff, length, ok := tagDiff(r)
+		if diff != test.diff || length != test.length || ok != test.ok {
+			t.Errorf("%s: expect %d, %d, %v, got %d, %d, %v\n",
+				test.name, test.diff, test.length, test.ok, diff, length, ok)
+		}
+	}
+}
+
+// TestDiffSkipped compares a read of skipped bases with the genome,
+// which has the same differences as its MD tag.
+func TestDiffSkipped(t *testing.T) {
+	d := &DiversityFilter{acc: "chr1", genome: []byte("GGACGTTTTTAGG")}
+	r := &sam.Record{
+		Ref:   newTestRef(t, "chr1"),
+		Pos:   2,
+		Cigar: sam.Cigar{sam.NewCigarOp(sam.CigarMatch, 3), sam.NewCigarOp(sam.CigarSkipped, 4), sam.NewCigarOp(sam.CigarMatch, 2)},
+		Seq:   sam.NewSeq([]byte("ACGTC")),
+	}
+	diff, length := d.Diff(r)
+	if diff != 1 || length != 5 {
+		t.Errorf("expect 1 difference in 5 bases, got %d in %d\n", diff, length)
+	}
+}
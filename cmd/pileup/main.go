@@ -12,12 +12,19 @@ var (
 	debug = app.Flag("debug", "Enable debug mode.").Bool()
 	ncpu  = app.Flag("ncpu", "number of CPUs for using.").Default("0").Int()
 
-	filterApp       = app.Command("filter", "filter reads.")
-	filterFeatureDB = filterApp.Arg("feature_db_path", "feature db path.").Required().String()
-	filterBam       = filterApp.Arg("bam_file", "bam file.").Required().String()
-	filterOut       = filterApp.Arg("out_file", "out file.").Required().String()
-	filterMaxDist   = filterApp.Flag("max_dist", "max distance.").Default("0.05").Float64()
-	filterMapQ      = filterApp.Flag("mapQ", "min mapQ").Default("30").Int()
+	filterApp        = app.Command("filter", "filter reads.")
+	filterFeatureDB  = filterApp.Arg("feature_db_path", "feature db path.").Required().String()
+	filterBam        = filterApp.Arg("bam_file", "bam file.").Required().String()
+	filterOut        = filterApp.Arg("out_file", "out file.").Required().String()
+	filterMaxDist    = filterApp.Flag("max_dist", "max distance (default 0.05), only without --filters.").Default("-1").Float64()
+	filterMapQ       = filterApp.Flag("mapQ", "min mapQ (default 30), only without --filters.").Default("-1").Int()
+	filterFilters    = filterApp.Flag("filters", "chain of filters, e.g. \"mapq>=30,proper_pair,max_softclip=0.1,diversity<=0.05\" (default by mapQ and max_dist)").Default("").String()
+	filterConfigFile = filterApp.Flag("filter_config", "YAML file of the chain of filters, overriding --filters").Default("").String()
+	filterUnpaired   = filterApp.Flag("unpaired", "filter reads without pairing mates").Bool()
+	filterReportFmt  = filterApp.Flag("report_format", "format of counts of reads in and out of filters, written next to the output (tsv, json or none)").Default("tsv").Enum("tsv", "json", "none")
+	filterHistBins   = filterApp.Flag("divergence_hist", "number of bins of the histogram of per-read divergence (0 for none)").Default("0").Int()
+	filterPairing    = filterApp.Flag("pairing", "pairing of mates: in memory for name- or coordinate-sorted input, or in a temporary LMDB (auto, memory or lmdb)").Default("auto").Enum("auto", "memory", "lmdb")
+	filterWindow     = filterApp.Flag("pairing_window", "max reads waiting for mates in memory, before spilling to a temporary LMDB").Default("1000000").Int()
 
 	featApp = app.Command("feat", "read and load genome sequence and features into a LMDB.")
 	featDir = featApp.Arg("genome_dir", "genome directory").Required().String()
@@ -60,11 +67,22 @@ func main() {
 	switch command {
 	case filterApp.FullCommand():
 		filtercmd := cmdFilter{
-			featureDB:   *filterFeatureDB,
-			bamFile:     *filterBam,
-			outFile:     *filterOut,
-			maxDistance: *filterMaxDist,
-			mapQ:        *filterMapQ,
+			featureDB:    *filterFeatureDB,
+			bamFile:      *filterBam,
+			outFile:      *filterOut,
+			maxDistance:  *filterMaxDist,
+			mapQ:         *filterMapQ,
+			filterSpecs:  splitFilters(*filterFilters),
+			unpaired:     *filterUnpaired,
+			reportFormat: *filterReportFmt,
+			histBins:     *filterHistBins,
+			pairing:      *filterPairing,
+			pairWindow:   *filterWindow,
+		}
+		if *filterConfigFile != "" {
+			config := readFilterConfig(*filterConfigFile)
+			filtercmd.filterSpecs = config.Filters
+			filtercmd.unpaired = filtercmd.unpaired || config.Unpaired
 		}
 		filtercmd.run()
 	case featApp.FullCommand():
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/heap"
+
+	"github.com/biogo/hts/sam"
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// matePairer pairs mates of sorted reads in memory.
+// Reads wait for their mates in a window,
+// from which reads are dropped once their mates can no longer come:
+// when the read name changes in name order,
+// or when reads pass the mate position or the reference in coordinate order.
+// If the window overflows, waiting reads are spilled to the temp db,
+// which is dropped in the same way.
+type matePairer struct {
+	d          *DiversityFilter
+	coordinate bool
+	window     int
+
+	pending   map[string]*sam.Record // reads waiting for mates, by name.
+	byMatePos matePosHeap            // pending reads by mate position, in coordinate order.
+	ref       string                 // reference of pending reads, in coordinate order.
+	name      string                 // name of pending reads, in name order.
+
+	// reads spilled to the temp db, whose names are kept,
+	// so that only spilled mates are looked up in the temp db.
+	spilled      bool
+	spilledNames map[string]bool
+	spillRef     string
+	spillMatePos int // max mate position of spilled reads.
+	spillName    string
+}
+
+func newMatePairer(d *DiversityFilter) *matePairer {
+	return &matePairer{
+		d:          d,
+		coordinate: d.SortOrder == sam.Coordinate,
+		window:     d.Window,
+		pending:    make(map[string]*sam.Record),
+	}
+}
+
+// advance drops reads whose mates would come before the read r.
+func (p *matePairer) advance(r *sam.Record) {
+	if p.coordinate {
+		ref := r.Ref.Name()
+		if ref != p.ref {
+			p.reset()
+			p.ref = ref
+		}
+		for len(p.byMatePos) > 0 && p.byMatePos[0].MatePos < r.Pos {
+			old := heap.Pop(&p.byMatePos).(*sam.Record)
+			if p.pending[old.Name] == old {
+				delete(p.pending, old.Name)
+			}
+		}
+		if p.spilled && (ref != p.spillRef || r.Pos > p.spillMatePos) {
+			p.dropSpilled()
+		}
+	} else if r.Name != p.name {
+		p.reset()
+		p.name = r.Name
+		if p.spilled {
+			p.dropSpilled()
+		}
+	}
+}
+
+// take returns and removes the waiting mate of the read r,
+// or nil if it is not found.
+func (p *matePairer) take(r *sam.Record) *sam.Record {
+	if mate, found := p.pending[r.Name]; found {
+		delete(p.pending, r.Name)
+		return mate
+	}
+	if p.spilledNames[r.Name] {
+		delete(p.spilledNames, r.Name)
+		return p.d.takeSpilled(r.Name)
+	}
+	return nil
+}
+
+// add adds the read r to wait for its mate.
+func (p *matePairer) add(r *sam.Record) {
+	if p.coordinate {
+		if r.MatePos < r.Pos {
+			return // its mate has passed.
+		}
+		heap.Push(&p.byMatePos, r)
+	}
+	p.pending[r.Name] = r
+	if len(p.pending) > p.window {
+		p.spill()
+	}
+}
+
+// spill moves pending reads to the temp db.
+func (p *matePairer) spill() {
+	if p.spilledNames == nil {
+		p.spilledNames = make(map[string]bool)
+	}
+	var reads []*sam.Record
+	for _, r := range p.pending {
+		reads = append(reads, r)
+		p.spilledNames[r.Name] = true
+		if r.MatePos > p.spillMatePos || !p.spilled {
+			p.spillMatePos = r.MatePos
+		}
+		p.spilled = true
+	}
+	p.spillRef, p.spillName = p.ref, p.name
+	p.d.spill(reads)
+	p.reset()
+}
+
+func (p *matePairer) dropSpilled() {
+	p.d.dropSpilled()
+	p.spilled = false
+	p.spilledNames = nil
+}
+
+func (p *matePairer) reset() {
+	p.pending = make(map[string]*sam.Record)
+	p.byMatePos = nil
+}
+
+// filterSorted keeps read pairs of divergence at most the cutoff,
+// pairing mates of sorted reads in memory.
+func (d *DiversityFilter) filterSorted(ch chan *sam.Record) chan *sam.Record {
+	filteredChan := make(chan *sam.Record)
+
+	go func() {
+		defer close(filteredChan)
+		p := newMatePairer(d)
+		for r := range ch {
+			ref := r.Ref.Name()
+			d.Counts.AddIn(ref)
+			p.advance(r)
+			if r.MateRef.Name() != ref {
+				continue
+			}
+			mate := p.take(r)
+			if mate == nil {
+				p.add(r)
+				continue
+			}
+			if d.keepPair(r, mate) {
+				d.Counts.AddOut(ref)
+				d.Counts.AddOut(ref)
+				filteredChan <- r
+				filteredChan <- mate
+			}
+		}
+	}()
+
+	return filteredChan
+}
+
+// spill writes reads waiting for mates to the temp db.
+func (d *DiversityFilter) spill(reads []*sam.Record) {
+	fn := func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("read", 0)
+		if err != nil {
+			return err
+		}
+		for _, r := range reads {
+			val, err := r.MarshalText()
+			if err != nil {
+				return err
+			}
+			if err := txn.Put(dbi, []byte(r.Name), val, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	d.update(fn)
+}
+
+// takeSpilled returns and removes the spilled read of the name from the temp db,
+// or nil if it is not found.
+func (d *DiversityFilter) takeSpilled(name string) (mate *sam.Record) {
+	fn := func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("read", 0)
+		if err != nil {
+			return err
+		}
+		key := []byte(name)
+		val, err := txn.Get(dbi, key)
+		if err != nil {
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		mate = &sam.Record{}
+		if err := mate.UnmarshalText(val); err != nil {
+			return err
+		}
+		return txn.Del(dbi, key, nil)
+	}
+	d.update(fn)
+	return
+}
+
+// dropSpilled removes all reads from the temp db.
+func (d *DiversityFilter) dropSpilled() {
+	fn := func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("read", 0)
+		if err != nil {
+			return err
+		}
+		return txn.Drop(dbi, false)
+	}
+	d.update(fn)
+}
+
+// matePosHeap is a min-heap of reads by mate position.
+type matePosHeap []*sam.Record
+
+func (h matePosHeap) Len() int            { return len(h) }
+func (h matePosHeap) Less(i, j int) bool  { return h[i].MatePos < h[j].MatePos }
+func (h matePosHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *matePosHeap) Push(x interface{}) { *h = append(*h, x.(*sam.Record)) }
+func (h *matePosHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func newTestRef(t *testing.T, name string) *sam.Reference {
+	ref, err := sam.NewReference(name, "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+// TestMatePairer feeds reads through a pairer with a tiny window,
+// and compares names of paired reads.
+func TestMatePairer(t *testing.T) {
+	chr1, chr2 := newTestRef(t, "chr1"), newTestRef(t, "chr2")
+	read := func(name string, ref *sam.Reference, pos, matePos int) *sam.Record {
+		return &sam.Record{Name: name, Ref: ref, Pos: pos, MateRef: ref, MatePos: matePos}
+	}
+
+	tests := []struct {
+		name    string
+		order   sam.SortOrder
+		window  int
+		reads   []*sam.Record
+		paired  []string
+		spilled bool // whether reads remain spilled at the end.
+	}{
+		{
+			name:  "mates at equal positions",
+			order: sam.Coordinate, window: 10,
+			reads: []*sam.Record{
+				read("a", chr1, 5, 5),
+				read("b", chr1, 5, 5),
+				read("a", chr1, 5, 5),
+				read("b", chr1, 5, 5),
+			},
+			paired: []string{"a", "b"},
+		},
+		{
+			name:  "dropped after the mate position",
+			order: sam.Coordinate, window: 10,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 5),
+				read("b", chr1, 2, 3),
+				read("b", chr1, 3, 2),
+				read("a", chr1, 6, 5),
+			},
+			paired: []string{"b"},
+		},
+		{
+			name:  "mate from the spill",
+			order: sam.Coordinate, window: 1,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 8),
+				read("b", chr1, 2, 9),
+				read("c", chr1, 3, 4),
+				read("c", chr1, 4, 3),
+				read("a", chr1, 8, 1),
+				read("b", chr1, 9, 2),
+			},
+			paired:  []string{"c", "a", "b"},
+			spilled: true,
+		},
+		{
+			name:  "spill dropped at a reference change",
+			order: sam.Coordinate, window: 1,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 8),
+				read("b", chr1, 2, 9),
+				read("a", chr2, 8, 1),
+				read("b", chr2, 9, 2),
+			},
+			paired: nil,
+		},
+		{
+			name:  "spill dropped after its mate positions",
+			order: sam.Coordinate, window: 1,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 8),
+				read("b", chr1, 2, 9),
+				read("c", chr1, 10, 20),
+				read("a", chr1, 20, 1),
+				read("c", chr1, 20, 10),
+			},
+			paired: []string{"c"},
+		},
+		{
+			name:  "adjacent names",
+			order: sam.QueryName, window: 10,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 8),
+				read("a", chr1, 8, 1),
+				read("b", chr1, 2, 9),
+				read("c", chr1, 3, 4),
+				read("b", chr1, 9, 2),
+			},
+			paired: []string{"a"},
+		},
+		{
+			name:  "name order spill",
+			order: sam.QueryName, window: 0,
+			reads: []*sam.Record{
+				read("a", chr1, 1, 8),
+				read("a", chr1, 8, 1),
+				read("b", chr1, 2, 9),
+			},
+			paired:  []string{"a"},
+			spilled: true,
+		},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "pairing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &DiversityFilter{SortOrder: test.order, Window: test.window}
+		d.CreateTempDB(dir)
+
+		var paired []string
+		p := newMatePairer(d)
+		for _, r := range test.reads {
+			p.advance(r)
+			if mate := p.take(r); mate != nil {
+				if mate.Name != r.Name {
+					t.Errorf("%s: %s paired with %s\n", test.name, r.Name, mate.Name)
+				}
+				paired = append(paired, r.Name)
+				continue
+			}
+			p.add(r)
+		}
+		if !reflect.DeepEqual(paired, test.paired) {
+			t.Errorf("%s: expect paired %v, got %v\n", test.name, test.paired, paired)
+		}
+		if p.spilled != test.spilled {
+			t.Errorf("%s: expect spilled %v, got %v\n", test.name, test.spilled, p.spilled)
+		}
+
+		d.db.Close()
+		os.RemoveAll(dir)
+	}
+}